@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/js10x/okta-token-vendor/vendor"
+)
+
+// Address the agent listens on when neither -listen nor -socket is given.
+const defaultAgentAddr = "127.0.0.1:8765"
+
+// Flags of the agent command, on top of the ones for vending a token.
+type agentFlags struct {
+	listen, socket, authFile, profiles string
+	refreshBefore                      time.Duration
+}
+
+func (a *agentFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&a.listen, "listen", "", "The loopback address serving the tokens over HTTP. Defaults to "+defaultAgentAddr+" when -socket is not given.")
+	fs.StringVar(&a.socket, "socket", "", "The Unix domain socket serving the tokens over HTTP.")
+	fs.StringVar(&a.authFile, "auth-file", defaultAgentAuthFile(), "Where the secret clients must send as a Bearer token is written when the agent starts.")
+	fs.StringVar(&a.profiles, "profiles", "", "Comma separated profiles of the config file to keep tokens for. Defaults to -profile.")
+	fs.DurationVar(&a.refreshBefore, "refresh-before", 5*time.Minute, "How long before expiry tokens are renewed.")
+}
+
+// Returns where the agent secret is written by default, next to the config file.
+func defaultAgentAuthFile() string {
+	config := defaultConfigPath()
+	if len(config) == 0 {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(config), "agent.secret")
+}
+
+// A profile whose token is kept fresh by the agent.
+type agentProfile struct {
+	name   string
+	flags  *vendFlags
+	keeper *vendor.TokenKeeper
+}
+
+// Runs in the background, keeping the tokens of one or more profiles fresh and serving them on
+// a loopback address or Unix domain socket, e.g.
+// curl -H "Authorization: Bearer $(cat ~/.config/oktv/agent.secret)" http://127.0.0.1:8765/token/dev
+func setupAgent(fs *flag.FlagSet) func(args []string) {
+
+	var f vendFlags
+	var a agentFlags
+	f.register(fs)
+	a.register(fs)
+	return func(args []string) {
+		if err := f.parse(fs, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		names := []string{f.profile}
+		if len(strings.TrimSpace(a.profiles)) > 0 {
+			names = nil
+			for _, name := range strings.Split(a.profiles, ",") {
+				if len(strings.TrimSpace(name)) > 0 {
+					names = append(names, strings.TrimSpace(name))
+				}
+			}
+		}
+
+		listeners, err := a.listeners()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		secret, err := a.writeSecret()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error occurred when writing the agent secret: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		// Vend the first token of each profile one at a time, since flows like the device flow
+		// need the user.
+		var profiles []*agentProfile
+		for _, name := range names {
+			profile, err := newAgentProfile(args, name, a.refreshBefore)
+			if err == nil {
+				fmt.Fprintf(os.Stderr, "Vending the token of profile [%v]\n", name)
+				err = profile.flags.validate(profile.keeper.Vendor())
+			}
+			if err == nil {
+				err = profile.keeper.Renew(ctx)
+				profile.flags.saveHAR()
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[%v] %v\n", name, err)
+				os.Exit(1)
+			}
+			if profile.flags.dpopKey != nil {
+				profile.flags.saveDPoPKey()
+			}
+			profiles = append(profiles, profile)
+		}
+
+		for _, profile := range profiles {
+			go profile.keeper.Run(ctx)
+		}
+
+		server := &http.Server{Handler: &agentHandler{secret: secret, profiles: profiles}}
+		for _, listener := range listeners {
+			fmt.Fprintf(os.Stderr, "Agent serving the tokens of [%v] on [%v]\n", strings.Join(names, ", "), listener.Addr())
+			go server.Serve(listener)
+		}
+
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+		for _, profile := range profiles {
+			profile.flags.saveHAR()
+		}
+	}
+}
+
+// Parses the flags of one profile, the ones given on the command line taking precedence.
+func newAgentProfile(args []string, name string, refreshBefore time.Duration) (*agentProfile, error) {
+
+	var f vendFlags
+	var ignored agentFlags
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	f.register(fs)
+	ignored.register(fs)
+	// The last -profile given wins.
+	if err := f.parse(fs, append(append([]string{}, args...), "-profile", name)); err != nil {
+		return nil, err
+	}
+	return &agentProfile{
+		name:   name,
+		flags:  &f,
+		keeper: vendor.NewTokenKeeper(vendor.NewTokenVendor(f.options()), refreshBefore),
+	}, nil
+}
+
+// Opens the loopback address and Unix domain socket the tokens are served on.
+func (a *agentFlags) listeners() ([]net.Listener, error) {
+
+	var listeners []net.Listener
+	listen := a.listen
+	if len(strings.TrimSpace(listen)) == 0 && len(strings.TrimSpace(a.socket)) == 0 {
+		listen = defaultAgentAddr
+	}
+
+	if len(strings.TrimSpace(listen)) > 0 {
+		host, _, err := net.SplitHostPort(listen)
+		if err != nil {
+			return nil, err
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return nil, fmt.Errorf("You must specify a loopback address to listen on, tokens are not served to other machines")
+		}
+		listener, err := net.Listen("tcp", listen)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if len(strings.TrimSpace(a.socket)) > 0 {
+		// Remove the socket left behind by an agent that did not shut down cleanly.
+		if info, err := os.Stat(a.socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(a.socket)
+		}
+		listener, err := net.Listen("unix", a.socket)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(a.socket, 0600); err != nil {
+			listener.Close()
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// Generates the secret clients must send, and writes it to the -auth-file for them.
+func (a *agentFlags) writeSecret() (string, error) {
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(random)
+	if len(strings.TrimSpace(a.authFile)) == 0 {
+		return "", fmt.Errorf("You must specify where to write the agent secret with -auth-file")
+	}
+	if err := os.MkdirAll(filepath.Dir(a.authFile), 0700); err != nil {
+		return "", err
+	}
+	if err := vendor.WriteFileAtomic(a.authFile, []byte(secret)); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// Serves the current token of a profile at /token/<profile>, or of the first profile at /token.
+type agentHandler struct {
+	secret   string
+	profiles []*agentProfile
+}
+
+// Token served by the agent.
+type agentToken struct {
+	Profile     string    `json:"profile"`
+	TokenType   string    `json:"token_type"`
+	AccessToken string    `json:"access_token"`
+	IDToken     string    `json:"id_token,omitempty"`
+	Scope       string    `json:"scope,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func (h *agentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	authorization := []byte(r.Header.Get("Authorization"))
+	if subtle.ConstantTimeCompare(authorization, []byte("Bearer "+h.secret)) != 1 {
+		http.Error(w, "The agent secret is missing or invalid.", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is supported.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var profile *agentProfile
+	switch {
+	case r.URL.Path == "/token":
+		profile = h.profiles[0]
+	case strings.HasPrefix(r.URL.Path, "/token/"):
+		name := strings.TrimPrefix(r.URL.Path, "/token/")
+		for _, candidate := range h.profiles {
+			if candidate.name == name {
+				profile = candidate
+			}
+		}
+	}
+	if profile == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	token, expiresAt, err := profile.keeper.Token()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(agentToken{
+		Profile:     profile.name,
+		TokenType:   token.TokenType,
+		AccessToken: token.AccessToken,
+		IDToken:     token.IDToken,
+		Scope:       token.Scope,
+		ExpiresAt:   expiresAt.UTC(),
+	})
+}
@@ -1,4 +1,4 @@
 $bin_name   = "oktv.bin"
 $Env:GOOS   = "linux"
 $Env:GOARCH = "amd64"
-go build -ldflags=-w -o $bin_name "main.go"
\ No newline at end of file
+go build -mod=mod -ldflags=-w -o $bin_name
\ No newline at end of file
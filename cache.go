@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/js10x/okta-token-vendor/vendor"
+)
+
+// Token kept in the cache, with when it expires.
+type cachedToken struct {
+	vendor.AccessTokenResponse
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Returns the cache file of the tokens vended with the flags, keyed by what the token is for,
+// e.g. ~/.cache/oktv/tokens/<hash>.json. Empty when there is no cache directory.
+func (f *vendFlags) cachePath() string {
+	return cachePath(vendor.NewTokenVendor(f.options()).Ops)
+}
+
+// Returns the cache file of the tokens vended with the options. The key is built from the
+// options once the environment and the defaults were applied, so that configurations only
+// told apart by e.g. their ISSUER environment variable don't share a file.
+func cachePath(ops vendor.Options) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	key := strings.Join([]string{ops.Issuer, ops.ClientID, ops.Flow, ops.Username, ops.Scope, ops.ResponseType}, "\n")
+	digest := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, "oktv", "tokens", hex.EncodeToString(digest[:16])+".json")
+}
+
+// Returns the cached token if it is still valid for at least the given duration.
+func readCachedToken(path string, minTTL time.Duration) *cachedToken {
+	cached := loadCachedToken(path)
+	if cached == nil || time.Until(cached.ExpiresAt) < minTTL {
+		return nil
+	}
+	return cached
+}
+
+// Returns the cached token, even when it expired, e.g. for its refresh token.
+func loadCachedToken(path string) *cachedToken {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var cached cachedToken
+	if err := json.Unmarshal(content, &cached); err != nil || len(cached.AccessToken) == 0 {
+		return nil
+	}
+	return &cached
+}
+
+// Writes the token to the cache, only readable by the current user.
+func writeCachedToken(path string, token *cachedToken) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	content, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return vendor.WriteFileAtomic(path, content)
+}
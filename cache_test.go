@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_CachePath(t *testing.T) {
+
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("CLIENT_ID", "CLIENT_ID")
+	t.Setenv("ISSUER", "https://okta-domain.com/oauth2/default")
+	base := (&vendFlags{}).cachePath()
+	if len(base) == 0 {
+		t.Fatalf("Expected a cache path")
+	}
+
+	scenarios := []struct {
+		name     string
+		issuer   string
+		flags    vendFlags
+		samePath bool
+	}{
+		{name: "same environment", issuer: "https://okta-domain.com/oauth2/default", samePath: true},
+		{name: "other issuer from the environment", issuer: "https://other.okta.com/oauth2/default"},
+		{name: "issuer from the flags", flags: vendFlags{iss: "https://okta-domain.com/oauth2/default"}, samePath: true},
+		{name: "client from the flags", issuer: "https://okta-domain.com/oauth2/default", flags: vendFlags{cid: "OTHER_CLIENT_ID"}},
+		{name: "scope from the flags", issuer: "https://okta-domain.com/oauth2/default", flags: vendFlags{scope: "openid offline_access"}},
+	}
+
+	for _, test := range scenarios {
+		t.Setenv("ISSUER", test.issuer)
+		flags := test.flags
+		if result := flags.cachePath(); (result == base) != test.samePath {
+			t.Errorf("[%v] Did not get the expected result. Expected ['%v'] Result ['%v']", test.name, test.samePath, result == base)
+		}
+	}
+}
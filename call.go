@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/js10x/okta-token-vendor/vendor"
+)
+
+// Headers given with repeated -H flags, e.g. -H "Accept: application/json".
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("headers must look like [Name: value]")
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// Calls an API with the vended token, like curl with the Authorization header filled in, e.g.
+// oktv call -profile dev POST https://api.example.com/orders -d '{"sku": "abc"}'
+// The token is reused from the cache while it is valid, and vended again once when the API
+// rejects it with an invalid_token error.
+func setupCall(fs *flag.FlagSet) func(args []string) {
+
+	var f vendFlags
+	var headers headerFlags
+	var data string
+	var raw, noCache bool
+	var minTTL time.Duration
+
+	f.register(fs)
+	fs.Var(&headers, "H", "A header to send, e.g. -H \"Accept: application/json\". Can be repeated.")
+	fs.StringVar(&data, "d", "", "The body to send. @file reads it from a file, and @- from stdin.")
+	fs.BoolVar(&raw, "raw", false, "Print JSON responses as they are, instead of pretty-printing them.")
+	fs.BoolVar(&noCache, "no-cache", false, "Always vend a new token instead of reusing the cached one.")
+	fs.DurationVar(&minTTL, "min-ttl", time.Minute, "How long the cached token must still be valid for to be reused.")
+	return func(args []string) {
+		// The flags can be given before and after the method and URL.
+		var positional []string
+		for {
+			if err := fs.Parse(args); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			args = fs.Args()
+			if len(args) == 0 {
+				break
+			}
+			positional = append(positional, args[0])
+			args = args[1:]
+		}
+		if err := f.applyProfile(fs); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		if len(positional) != 2 {
+			fmt.Fprintf(os.Stderr, "You must specify the method and URL, e.g. oktv call GET https://api.example.com/orders\n")
+			os.Exit(1)
+		}
+
+		body, err := readBody(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error occurred when reading the body: %v\n", err)
+			os.Exit(1)
+		}
+		request, err := http.NewRequest(strings.ToUpper(positional[0]), positional[1], bytes.NewReader(body))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		for _, header := range headers {
+			i := strings.Index(header, ":")
+			request.Header.Add(strings.TrimSpace(header[:i]), strings.TrimSpace(header[i+1:]))
+		}
+		if len(body) > 0 && len(request.Header.Get("Content-Type")) == 0 {
+			if json.Valid(body) {
+				request.Header.Set("Content-Type", "application/json")
+			} else {
+				request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+		}
+
+		// DPoP bound tokens are useless without their proofs, so they are not cached.
+		cachePath := ""
+		if !noCache && !f.dpop {
+			cachePath = f.cachePath()
+		}
+
+		oktv := vendor.NewTokenVendor(f.options())
+		keeper := vendor.NewTokenKeeper(oktv, 0)
+		cached := readCachedToken(cachePath, minTTL)
+		if cached != nil {
+			keeper.Set(&cached.AccessTokenResponse, cached.ExpiresAt)
+		} else if err := f.validate(oktv); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		// Calls the API with the TLS and proxy settings used to vend the token.
+		base, err := vendor.NewTransport(oktv.Ops)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		client := &http.Client{Transport: vendor.NewTokenTransport(keeper, base)}
+		response, err := client.Do(request.WithContext(ctx))
+		f.saveHAR()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		defer response.Body.Close()
+
+		// Cache the token vended for the call, for the next one.
+		if token, expiresAt, err := keeper.Token(); err == nil && len(cachePath) > 0 && (cached == nil || token != &cached.AccessTokenResponse) {
+			if err := writeCachedToken(cachePath, &cachedToken{AccessTokenResponse: *token, ExpiresAt: expiresAt}); err != nil {
+				fmt.Fprintf(os.Stderr, "Error occurred when caching the token: %v\n", err)
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "%v %v\n", response.Proto, response.Status)
+		if err := printBody(response, raw); err != nil {
+			fmt.Fprintf(os.Stderr, "Error occurred when reading the response: %v\n", err)
+			os.Exit(1)
+		}
+		if response.StatusCode >= http.StatusBadRequest {
+			os.Exit(1)
+		}
+	}
+}
+
+// Reads the -d body, from a file with @file or from stdin with @-.
+func readBody(data string) ([]byte, error) {
+	switch {
+	case data == "@-":
+		return ioutil.ReadAll(os.Stdin)
+	case strings.HasPrefix(data, "@"):
+		return ioutil.ReadFile(data[1:])
+	}
+	return []byte(data), nil
+}
+
+// Prints the body of the response to stdout, pretty-printing JSON unless asked not to.
+func printBody(response *http.Response, raw bool) error {
+
+	if raw || !strings.Contains(strings.ToLower(response.Header.Get("Content-Type")), "json") {
+		_, err := io.Copy(os.Stdout, response.Body)
+		return err
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, body, "", "  "); err != nil {
+		// Not actually JSON, print it as it is.
+		os.Stdout.Write(body)
+		return nil
+	}
+	fmt.Println(strings.TrimRight(pretty.String(), "\n"))
+	return nil
+}
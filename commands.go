@@ -0,0 +1,268 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A command of the CLI. The flags and usage text, the help, the completion scripts and the man
+// page are all generated from these definitions.
+type command struct {
+	name string
+	// Arguments taken after the flags, e.g. "<method> <url>".
+	args    string
+	summary string
+	// Registers the flags of the command, and returns the function running it.
+	setup func(fs *flag.FlagSet) func(args []string)
+}
+
+// Returns the commands of the CLI, in the order they are listed in the help.
+func allCommands() []command {
+	return []command{
+		{name: "get", summary: "Vend a token with the configured flow and print it. The default command.", setup: setupGet},
+		{name: "refresh", summary: "Get a new access token with a refresh token, the cached one by default.", setup: setupRefresh},
+		{name: "decode", args: "[token]", summary: "Print the header and claims of a JWT, read from stdin when not given.", setup: setupDecode},
+		{name: "verify", args: "[token]", summary: "Verify the signature, issuer and expiry of a JWT against the keys of the ISSUER.", setup: setupVerify},
+		{name: "revoke", args: "[token]", summary: "Revoke a token, or the cached tokens when not given.", setup: setupRevoke},
+		{name: "doctor", summary: "Check the configuration against the ISSUER and report each check as pass, warn or fail.", setup: setupDoctor},
+		{name: "exchange", summary: "Trade a token for one scoped to a downstream audience.", setup: setupExchange},
+		{name: "exec", args: "-- <command> [args...]", summary: "Run a command with a fresh token in its environment.", setup: setupExec},
+		{name: "call", args: "<method> <url>", summary: "Call an API with the token, like curl.", setup: setupCall},
+		{name: "agent", summary: "Keep tokens fresh in the background and serve them locally.", setup: setupAgent},
+		{name: "dpop-proof", summary: "Mint a DPoP proof for calling an API with a DPoP bound token.", setup: setupDPoPProof},
+		{name: "config", args: "<path|list|show|set|unset> [profile] [flag] [value]", summary: "Show and edit the profiles of the config file.", setup: setupConfig},
+		{name: "mock-server", summary: "Run a fake Okta locally for offline testing.", setup: setupMockServer},
+		{name: "completion", args: "<bash|zsh|fish>", summary: "Print the shell completion script.", setup: setupCompletion},
+		{name: "man", summary: "Print the man page.", setup: setupMan},
+		{name: "help", args: "[command]", summary: "Show the help of the CLI or of a command.", setup: setupHelp},
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range allCommands() {
+		if cmd.name == name {
+			return &cmd
+		}
+	}
+	return nil
+}
+
+// Returns the flag set of the command, printing its usage on -h.
+func (c *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	fs.Usage = func() { c.printUsage(fs) }
+	return fs
+}
+
+// Returns the flags of the command, sorted by name, for the help and generated files.
+func (c *command) flags() []*flag.Flag {
+	fs := c.flagSet()
+	c.setup(fs)
+	var flags []*flag.Flag
+	fs.VisitAll(func(f *flag.Flag) { flags = append(flags, f) })
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+func (c *command) printUsage(fs *flag.FlagSet) {
+	out := fs.Output()
+	fmt.Fprintf(out, "Usage: oktv %v [flags] %v\n\n%v\n", c.name, c.args, c.summary)
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintf(out, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+}
+
+// Reports whether the flag is a switch, given without a value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// Shows the list of commands, or the help of one of them.
+func setupHelp(fs *flag.FlagSet) func(args []string) {
+	return func(args []string) {
+		fs.Parse(args)
+		if fs.NArg() > 0 {
+			cmd := findCommand(fs.Arg(0))
+			if cmd == nil {
+				fmt.Fprintf(os.Stderr, "Unknown command [%v]\n", fs.Arg(0))
+				os.Exit(2)
+			}
+			help := cmd.flagSet()
+			help.SetOutput(os.Stdout)
+			cmd.setup(help)
+			cmd.printUsage(help)
+			return
+		}
+
+		fmt.Printf("Usage: oktv <command> [flags]\n\nVends Okta tokens from the command line. Without a command, a token is vended as with get.\n\nCommands:\n")
+		for _, cmd := range allCommands() {
+			fmt.Printf("  %-12v %v\n", cmd.name, cmd.summary)
+		}
+		fmt.Printf("\nRun oktv help <command> for the flags of a command.\n")
+	}
+}
+
+// Prints the completion script of a shell.
+func setupCompletion(fs *flag.FlagSet) func(args []string) {
+	return func(args []string) {
+		fs.Parse(args)
+		switch fs.Arg(0) {
+		case "bash":
+			fmt.Print(bashCompletion(allCommands()))
+		case "zsh":
+			fmt.Print(zshCompletion(allCommands()))
+		case "fish":
+			fmt.Print(fishCompletion(allCommands()))
+		default:
+			fmt.Fprintf(os.Stderr, "You must specify the shell: bash, zsh or fish\n")
+			os.Exit(2)
+		}
+	}
+}
+
+// Prints the man page.
+func setupMan(fs *flag.FlagSet) func(args []string) {
+	return func(args []string) {
+		fs.Parse(args)
+		fmt.Print(manPage(allCommands()))
+	}
+}
+
+func flagNames(cmd command) string {
+	var names []string
+	for _, f := range cmd.flags() {
+		names = append(names, "-"+f.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+// Returns the bash completion script, e.g. for source <(oktv completion bash).
+func bashCompletion(commands []command) string {
+
+	var script strings.Builder
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	script.WriteString("# bash completion for oktv, generated by oktv completion bash\n")
+	script.WriteString("_oktv() {\n")
+	script.WriteString("\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	script.WriteString("\tif [ \"$COMP_CWORD\" -eq 1 ] && [[ \"$cur\" != -* ]]; then\n")
+	fmt.Fprintf(&script, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	script.WriteString("\t\treturn\n\tfi\n")
+	script.WriteString("\tcase \"${COMP_WORDS[1]}\" in\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&script, "\t%v) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", cmd.name, flagNames(cmd))
+	}
+	// Without a command, the flags are the ones of get.
+	fmt.Fprintf(&script, "\t*) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", flagNames(commands[0]))
+	script.WriteString("\tesac\n}\n")
+	script.WriteString("complete -o default -F _oktv oktv\n")
+	return script.String()
+}
+
+// Returns the zsh completion script, to be saved as _oktv in a directory of $fpath.
+func zshCompletion(commands []command) string {
+
+	escape := strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]", ":", "\\:")
+	var script strings.Builder
+	script.WriteString("#compdef oktv\n# zsh completion for oktv, generated by oktv completion zsh\n\n")
+	script.WriteString("_oktv() {\n\tlocal -a commands\n\tcommands=(\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&script, "\t\t'%v:%v'\n", cmd.name, escape.Replace(cmd.summary))
+	}
+	script.WriteString("\t)\n")
+	script.WriteString("\tif (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then\n\t\t_describe 'command' commands\n\t\treturn\n\tfi\n")
+	script.WriteString("\tcase $words[2] in\n")
+	for i, cmd := range commands {
+		pattern := cmd.name
+		if i == 0 {
+			// Without a command, the flags are the ones of get.
+			pattern += "|-*"
+		}
+		fmt.Fprintf(&script, "\t%v)\n\t\t_arguments \\\n", pattern)
+		for _, f := range cmd.flags() {
+			spec := fmt.Sprintf("-%v[%v]", f.Name, escape.Replace(f.Usage))
+			if !isBoolFlag(f) {
+				spec += ":value:_files"
+			}
+			fmt.Fprintf(&script, "\t\t\t'%v' \\\n", spec)
+		}
+		script.WriteString("\t\t\t'*::arg:_files'\n\t\t;;\n")
+	}
+	script.WriteString("\tesac\n}\n\n_oktv \"$@\"\n")
+	return script.String()
+}
+
+// Returns the fish completion script, e.g. for oktv completion fish | source.
+func fishCompletion(commands []command) string {
+
+	escape := strings.NewReplacer("'", "\\'")
+	var script strings.Builder
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	script.WriteString("# fish completion for oktv, generated by oktv completion fish\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&script, "complete -c oktv -f -n 'not __fish_seen_subcommand_from %v' -a %v -d '%v'\n", strings.Join(names, " "), cmd.name, escape.Replace(cmd.summary))
+	}
+	for _, cmd := range commands {
+		for _, f := range cmd.flags() {
+			required := " -r"
+			if isBoolFlag(f) {
+				required = ""
+			}
+			fmt.Fprintf(&script, "complete -c oktv -n '__fish_seen_subcommand_from %v' -o %v%v -d '%v'\n", cmd.name, f.Name, required, escape.Replace(f.Usage))
+		}
+	}
+	return script.String()
+}
+
+// Returns the man page, in roff, e.g. for oktv man > /usr/local/share/man/man1/oktv.1.
+func manPage(commands []command) string {
+
+	escape := func(text string) string {
+		text = strings.ReplaceAll(text, "\\", "\\e")
+		text = strings.ReplaceAll(text, "-", "\\-")
+		if strings.HasPrefix(text, ".") || strings.HasPrefix(text, "'") {
+			text = "\\&" + text
+		}
+		return text
+	}
+
+	var page strings.Builder
+	page.WriteString(".TH OKTV 1 \"\" \"oktv\" \"User Commands\"\n")
+	page.WriteString(".SH NAME\noktv \\- vend Okta tokens from the command line\n")
+	page.WriteString(".SH SYNOPSIS\n.B oktv\n[\\fIcommand\\fR] [\\fIflags\\fR] [\\fIargs\\fR]\n")
+	page.WriteString(".SH DESCRIPTION\nVends access tokens from an Okta authorization server with the authorization code flow with PKCE, the browser, device and password flows, and keeps them fresh. Without a command, a token is vended as with \\fBget\\fR.\n")
+	page.WriteString(".SH COMMANDS\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&page, ".SS \"oktv %v %v\"\n%v\n", cmd.name, escape(strings.TrimSpace("[flags] "+cmd.args)), escape(cmd.summary))
+		for _, f := range cmd.flags() {
+			name, usage := flag.UnquoteUsage(f)
+			fmt.Fprintf(&page, ".TP\n.B \\-%v", escape(f.Name))
+			if len(name) > 0 {
+				fmt.Fprintf(&page, " \\fI%v\\fR", escape(name))
+			}
+			page.WriteString("\n" + escape(usage))
+			if len(f.DefValue) > 0 && f.DefValue != "false" && f.DefValue != "0" {
+				fmt.Fprintf(&page, " Defaults to %v.", escape(f.DefValue))
+			}
+			page.WriteString("\n")
+		}
+	}
+	page.WriteString(".SH ENVIRONMENT\n")
+	for _, env := range []string{"CLIENT_ID", "CLIENT_SECRET", "ISSUER", "REDIRECT_URI"} {
+		fmt.Fprintf(&page, ".TP\n.B %v\nUsed when the matching flag is not given.\n", escape(env))
+	}
+	page.WriteString(".SH FILES\n.TP\n.I ~/.config/oktv/config.json\nThe profiles of flags, see \\fBoktv config\\fR.\n")
+	return page.String()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/js10x/okta-token-vendor/vendor"
+)
+
+// Checks the configuration against the authorization server and reports each check as pass,
+// warn or fail. Exits 1 when any check failed.
+func setupDoctor(fs *flag.FlagSet) func(args []string) {
+
+	var f vendFlags
+	var authorize bool
+	f.register(fs)
+	fs.BoolVar(&authorize, "authorize", false, "Also send a dry /authorize with prompt=none to check the REDIRECT URI is registered for the CLIENT ID, without logging in.")
+	return func(args []string) {
+		if err := f.parse(fs, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		oktv := vendor.NewTokenVendor(f.options())
+
+		var checks []vendor.Check
+		for _, err := range []error{f.tlsErr, f.certErr, f.dpopErr, f.sinkErr} {
+			if err != nil {
+				checks = append(checks, vendor.Check{Name: "settings", Status: vendor.CheckFail, Detail: err.Error()})
+			}
+		}
+		checks = append(checks, oktv.Diagnose(authorize)...)
+		f.saveHAR()
+
+		failed := false
+		for _, check := range checks {
+			fmt.Printf("[%-4v] %v: %v\n", strings.ToUpper(check.Status), check.Name, check.Detail)
+			failed = failed || check.Status == vendor.CheckFail
+		}
+		if failed {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/js10x/okta-token-vendor/vendor"
+)
+
+// Mints a DPoP proof for calling an API with a DPoP bound token, e.g.
+// curl -H "Authorization: DPoP $TOKEN" -H "DPoP: $(oktv dpop-proof -o token.txt -method GET -url ...)".
+func setupDPoPProof(fs *flag.FlagSet) func(args []string) {
+
+	var method, target, keyPath, out, token, nonce string
+
+	fs.StringVar(&method, "method", "GET", "The HTTP method of the request the proof is for.")
+	fs.StringVar(&target, "url", "", "The URL of the request the proof is for.")
+	fs.StringVar(&out, "o", "", "The token file written by -o when vending the token. The token is bound to the proof, and the key is read from next to it.")
+	fs.StringVar(&keyPath, "key", "", "The DPoP key file. Defaults to the -o file with a .dpop-key.pem extension.")
+	fs.StringVar(&token, "token", "", "The access token the proof is bound to. Defaults to the token in the -o file.")
+	fs.StringVar(&nonce, "nonce", "", "The nonce handed out by the resource server in its DPoP-Nonce header.")
+	return func(args []string) {
+		fs.Parse(args)
+
+		if len(strings.TrimSpace(keyPath)) == 0 && len(strings.TrimSpace(out)) > 0 {
+			keyPath = out + ".dpop-key.pem"
+		}
+		switch {
+		case len(strings.TrimSpace(target)) == 0:
+			fmt.Fprintf(os.Stderr, "You must specify the URL the proof is for\n")
+			os.Exit(1)
+		case len(strings.TrimSpace(keyPath)) == 0:
+			fmt.Fprintf(os.Stderr, "You must specify the DPoP key with -key or -o\n")
+			os.Exit(1)
+		}
+
+		key, err := vendor.LoadDPoPKey(keyPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error occurred when loading the DPoP key: %v\n", err)
+			os.Exit(1)
+		}
+		if len(token) == 0 && len(strings.TrimSpace(out)) > 0 {
+			saved, err := ioutil.ReadFile(out)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error occurred when reading the token file: %v\n", err)
+				os.Exit(1)
+			}
+			token = strings.TrimSpace(string(saved))
+		}
+		key.SetNonce(nonce)
+
+		proof, err := key.Proof(strings.ToUpper(method), target, token)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error occurred when signing the DPoP proof: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(proof)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/js10x/okta-token-vendor/vendor"
+)
+
+// Formats of the -dry-run output.
+const (
+	dryRunText = "text"
+	dryRunCurl = "curl"
+)
+
+// Prints the requests the flow would make, with secrets redacted, as text or as a curl script.
+func printDryRun(w io.Writer, planned []vendor.PlannedRequest, format string) error {
+	if format == dryRunCurl {
+		return printDryRunCurl(w, planned)
+	}
+
+	for i, p := range planned {
+		body, err := requestBody(p.Request)
+		if err != nil {
+			return err
+		}
+		header := vendor.RedactHeader(p.Request.Header)
+
+		step := p.Step
+		if p.Browser {
+			step += " (opened in the browser)"
+		}
+		fmt.Fprintf(w, "\n# %v. %v\n", i+1, step)
+		fmt.Fprintf(w, "%v %v\n", p.Request.Method, vendor.RedactURL(p.Request.URL.String()))
+		for _, name := range headerNames(header) {
+			for _, value := range header[name] {
+				fmt.Fprintf(w, "%v: %v\n", name, value)
+			}
+		}
+		if len(body) > 0 {
+			fmt.Fprintf(w, "\n%s\n", vendor.RedactBody(p.Request.Header.Get("Content-Type"), body))
+		}
+	}
+	return nil
+}
+
+// Extracts the value each placeholder stands for from the answer of the request yielding it.
+var dryRunExtracts = map[string]string{
+	vendor.DryRunSessionToken: "jq -r .sessionToken",
+	vendor.DryRunRequestURI:   "jq -r .request_uri",
+	vendor.DryRunDeviceCode:   "tee /dev/stderr | jq -r .device_code",
+	vendor.DryRunCode:         `sed -n 's/.*[?&#]code=\([^&]*\).*/\1/p'`,
+}
+
+// Prints a script replaying the requests with curl. Only the passwords and client secrets are
+// redacted, and the values only known once an earlier request was answered are read into shell
+// variables, so that the script runs once the credentials are filled in.
+func printDryRunCurl(w io.Writer, planned []vendor.PlannedRequest) error {
+
+	fmt.Fprintf(w, "#!/bin/sh\n# Passwords and client secrets are %v, fill them in before running. Requires curl and jq.\nset -e\n", vendor.Redacted)
+
+	// Placeholders answered by the requests printed so far.
+	known := map[string]bool{}
+	for i, p := range planned {
+		body, err := requestBody(p.Request)
+		if err != nil {
+			return err
+		}
+		header := vendor.RedactHeader(p.Request.Header)
+		target := shellURL(p.Request.URL.String(), known)
+
+		step := p.Step
+		if p.Browser {
+			step += " (opened in the browser)"
+		}
+		fmt.Fprintf(w, "\n# %v. %v\n", i+1, step)
+
+		if p.Browser {
+			fmt.Fprintf(w, "# %v\n", p.Request.URL.String())
+			if len(p.Yields) > 0 {
+				fmt.Fprintf(w, "printf 'Paste the %v of the redirect: ' >&2\nread -r %v\n", strings.ToLower(p.Yields), p.Yields)
+				known[p.Yields] = true
+			}
+			continue
+		}
+
+		var command strings.Builder
+		fmt.Fprintf(&command, "curl -sS -X %v %v", p.Request.Method, target)
+		if p.Yields == vendor.DryRunCode {
+			// The code is in the URL the authorization server redirects to.
+			command.WriteString(" \\\n  -o /dev/null -w '%{redirect_url}'")
+		}
+		for _, name := range headerNames(header) {
+			// curl computes the length of the body itself.
+			if name == "Content-Length" {
+				continue
+			}
+			for _, value := range header[name] {
+				fmt.Fprintf(&command, " \\\n  -H %v", shellQuote(name+": "+value))
+			}
+		}
+		if len(body) > 0 {
+			contentType := p.Request.Header.Get("Content-Type")
+			body = vendor.RedactCredentials(contentType, body)
+			if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+				fmt.Fprintf(&command, " \\\n  --data-raw %v", shellForm("", string(body), known))
+			} else {
+				fmt.Fprintf(&command, " \\\n  --data-raw %v", shellQuote(string(body)))
+			}
+		}
+
+		if extract, ok := dryRunExtracts[p.Yields]; ok {
+			fmt.Fprintf(w, "%v=$(%v | %v)\n", p.Yields, command.String(), extract)
+			known[p.Yields] = true
+		} else {
+			fmt.Fprintln(w, command.String())
+		}
+		if p.Yields == vendor.DryRunDeviceCode {
+			fmt.Fprintf(w, "printf 'Approve the device at the verification_uri_complete above, then press enter: ' >&2\nread -r _\n")
+		}
+	}
+	return nil
+}
+
+func headerNames(header http.Header) []string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Quotes the URL for a POSIX shell, expanding the known placeholders of its query.
+func shellURL(raw string, known map[string]bool) string {
+	i := strings.Index(raw, "?")
+	if i < 0 {
+		return shellQuote(raw)
+	}
+	return shellForm(raw[:i+1], raw[i+1:], known)
+}
+
+// Quotes the form encoded values after the prefix for a POSIX shell, expanding the values that
+// are known placeholders from the shell variables of the same name.
+func shellForm(prefix string, encoded string, known map[string]bool) string {
+
+	values, err := url.ParseQuery(encoded)
+	if err != nil {
+		return shellQuote(prefix + encoded)
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var word strings.Builder
+	literal, first := prefix, true
+	for _, name := range names {
+		for _, value := range values[name] {
+			if !first {
+				literal += "&"
+			}
+			first = false
+			literal += url.QueryEscape(name) + "="
+			if !known[value] {
+				literal += url.QueryEscape(value)
+				continue
+			}
+			word.WriteString(shellQuote(literal) + `"$` + value + `"`)
+			literal = ""
+		}
+	}
+	if len(literal) > 0 || word.Len() == 0 {
+		word.WriteString(shellQuote(literal))
+	}
+	return word.String()
+}
+
+func requestBody(request *http.Request) ([]byte, error) {
+	if request.GetBody == nil {
+		return nil, nil
+	}
+	body, err := request.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+// Quotes a value for a POSIX shell.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/js10x/okta-token-vendor/vendor"
+)
+
+func Test_PrintDryRun(t *testing.T) {
+
+	scenarios := []struct {
+		name       string
+		options    []vendor.Option
+		format     string
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name:       "text",
+			options:    []vendor.Option{vendor.Flow(vendor.FlowPKCE), vendor.Credentials("user", "pw")},
+			format:     dryRunText,
+			expected:   []string{"POST https://okta-domain.com/api/v1/authn", "sessionToken=%5BREDACTED%5D", "code=%5BREDACTED%5D&code_verifier=%5BREDACTED%5D"},
+			unexpected: []string{`"pw"`},
+		},
+		{
+			name:    "curl pkce",
+			options: []vendor.Option{vendor.Flow(vendor.FlowPKCE), vendor.Credentials("user", "pw")},
+			format:  dryRunCurl,
+			expected: []string{
+				"SESSION_TOKEN=$(curl -sS -X POST 'https://okta-domain.com/api/v1/authn'",
+				`"password":"[REDACTED]"`,
+				"| jq -r .sessionToken)",
+				`&sessionToken='"$SESSION_TOKEN"'&`,
+				"CODE=$(curl -sS -X GET 'https://okta-domain.com/oauth2/default/v1/authorize?",
+				`--data-raw 'client_id=CLIENT_ID&code='"$CODE"'&code_verifier=`,
+			},
+			unexpected: []string{`"pw"`, "code_verifier=%5BREDACTED%5D"},
+		},
+		{
+			name:    "curl pkce with PAR",
+			options: []vendor.Option{vendor.Flow(vendor.FlowPKCE), vendor.Credentials("user", "pw"), vendor.PAR(true)},
+			format:  dryRunCurl,
+			expected: []string{
+				"REQUEST_URI=$(curl -sS -X POST 'https://okta-domain.com/oauth2/default/v1/par'",
+				"| jq -r .request_uri)",
+				`/v1/authorize?client_id=CLIENT_ID&request_uri='"$REQUEST_URI"`,
+			},
+		},
+		{
+			name:     "curl browser",
+			options:  []vendor.Option{vendor.Flow(vendor.FlowBrowser)},
+			format:   dryRunCurl,
+			expected: []string{"# https://okta-domain.com/oauth2/default/v1/authorize?", "read -r CODE", `code='"$CODE"'`},
+		},
+		{
+			name:     "curl device",
+			options:  []vendor.Option{vendor.Flow(vendor.FlowDevice)},
+			format:   dryRunCurl,
+			expected: []string{"| tee /dev/stderr | jq -r .device_code)", `device_code='"$DEVICE_CODE"'`},
+		},
+		{
+			name:       "curl client secret",
+			options:    []vendor.Option{vendor.Flow(vendor.FlowPassword), vendor.Credentials("user", "pw"), vendor.ClientSecret("CLIENT_SECRET")},
+			format:     dryRunCurl,
+			expected:   []string{"-H 'Authorization: Basic [REDACTED]'", "password=%5BREDACTED%5D"},
+			unexpected: []string{"CLIENT_SECRET", "password=pw"},
+		},
+	}
+
+	for _, test := range scenarios {
+
+		planned, err := vendor.NewTokenVendor(append([]vendor.Option{
+			vendor.Issuer("https://okta-domain.com/oauth2/default"),
+			vendor.ClientID("CLIENT_ID"),
+			vendor.RedirectURI("http://localhost:4200/login/callback"),
+		}, test.options...)).DryRun()
+		if err != nil {
+			t.Fatalf("[%v] Unexpected error [%v]", test.name, err)
+		}
+		var out bytes.Buffer
+		if err := printDryRun(&out, planned, test.format); err != nil {
+			t.Fatalf("[%v] Unexpected error [%v]", test.name, err)
+		}
+
+		for _, expected := range test.expected {
+			if !strings.Contains(out.String(), expected) {
+				t.Errorf("[%v] Did not get the expected result. Expected ['%v'] Result ['%v']", test.name, expected, out.String())
+			}
+		}
+		for _, unexpected := range test.unexpected {
+			if strings.Contains(out.String(), unexpected) {
+				t.Errorf("[%v] Did not expect ['%v'] Result ['%v']", test.name, unexpected, out.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/js10x/okta-token-vendor/vendor"
+)
+
+// Short names accepted by -subject-token-type.
+var tokenTypes = map[string]string{
+	"access_token":  vendor.TokenTypeAccessToken,
+	"refresh_token": vendor.TokenTypeRefreshToken,
+	"id_token":      vendor.TokenTypeIDToken,
+	"jwt":           vendor.TokenTypeJWT,
+}
+
+// Trades a token for one scoped to a downstream audience, reproducing what an API gateway
+// does. The subject token is either given with -subject-token, or freshly vended using the
+// same flags as getting a token.
+func setupExchange(fs *flag.FlagSet) func(args []string) {
+
+	var f vendFlags
+	var subjectToken, subjectTokenType, audience, scope, exchangeCID, exchangeSecret string
+
+	f.register(fs)
+	fs.StringVar(&subjectToken, "subject-token", "", "The token to exchange. When empty, a token is vended first using the other flags.")
+	fs.StringVar(&subjectTokenType, "subject-token-type", "access_token", "The type of the subject token: access_token, id_token, refresh_token, jwt or a token type URN.")
+	fs.StringVar(&audience, "audience", "", "The audience of the downstream service the token is for.")
+	fs.StringVar(&scope, "exchange-scope", "", "Space separated scopes to request for the downstream token.")
+	fs.StringVar(&exchangeCID, "exchange-cid", "", "The client ID of the service app performing the exchange. Defaults to -cid.")
+	fs.StringVar(&exchangeSecret, "exchange-secret", "", "The client secret of the service app performing the exchange. Defaults to -secret.")
+	return func(args []string) {
+		if err := f.parse(fs, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		if urn, ok := tokenTypes[subjectTokenType]; ok {
+			subjectTokenType = urn
+		}
+
+		// 1.) Vend the subject token, unless one was provided.
+		if len(strings.TrimSpace(subjectToken)) == 0 {
+			accessToken, err := f.vend(vendor.NewTokenVendor(f.options()))
+			if err != nil {
+				f.saveHAR()
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			subjectToken = accessToken.AccessToken
+			subjectTokenType = vendor.TokenTypeAccessToken
+		}
+
+		// 2.) Exchange it for the downstream token.
+		exchanger := vendor.NewTokenVendor(append(f.options(), vendor.ClientID(exchangeCID), vendor.ClientSecret(exchangeSecret)))
+		switch {
+		case len(strings.TrimSpace(exchanger.Ops.ClientID)) <= 0:
+			fmt.Fprintf(os.Stderr, "You must specify a CLIENT ID\n")
+			os.Exit(1)
+		case len(strings.TrimSpace(exchanger.Ops.Issuer)) <= 0:
+			fmt.Fprintf(os.Stderr, "You must specify an ISSUER\n")
+			os.Exit(1)
+		}
+
+		exchanged, err := exchanger.Exchange(subjectToken, subjectTokenType, audience, strings.Fields(scope))
+		f.saveHAR()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error occurred when exchanging the token: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(exchanged.ToString())
+	}
+}
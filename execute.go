@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/js10x/okta-token-vendor/vendor"
+)
+
+// Runs a command with a fresh token in its environment, e.g.
+// oktv exec -profile dev -- curl -H "Authorization: Bearer $ACCESS_TOKEN" https://api.example.com
+// The token is reused from the cache while it is valid, and the exit code of the command is
+// returned as is.
+func setupExec(fs *flag.FlagSet) func(args []string) {
+
+	var f vendFlags
+	var accessTokenEnv, idTokenEnv, expiresAtEnv string
+	var noCache bool
+	var minTTL time.Duration
+
+	f.register(fs)
+	fs.StringVar(&accessTokenEnv, "access-token-env", "ACCESS_TOKEN", "The environment variable the access token is passed in. Empty to leave it out.")
+	fs.StringVar(&idTokenEnv, "id-token-env", "ID_TOKEN", "The environment variable the ID token is passed in. Empty to leave it out.")
+	fs.StringVar(&expiresAtEnv, "expires-at-env", "TOKEN_EXPIRES_AT", "The environment variable the expiry of the access token is passed in, as an RFC 3339 time. Empty to leave it out.")
+	fs.BoolVar(&noCache, "no-cache", false, "Always vend a new token instead of reusing the cached one.")
+	fs.DurationVar(&minTTL, "min-ttl", time.Minute, "How long the cached token must still be valid for to be reused.")
+	return func(args []string) {
+		if err := f.parse(fs, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		command := fs.Args()
+		if len(command) == 0 {
+			fmt.Fprintf(os.Stderr, "You must specify the command to run after --\n")
+			os.Exit(1)
+		}
+
+		// DPoP bound tokens are useless without their proofs, so they are not cached.
+		cachePath := ""
+		if !noCache && !f.dpop {
+			cachePath = f.cachePath()
+		}
+
+		token := readCachedToken(cachePath, minTTL)
+		if token == nil {
+			var err error
+			token, err = f.vendForExec()
+			f.saveHAR()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			if len(cachePath) > 0 {
+				if err := writeCachedToken(cachePath, token); err != nil {
+					fmt.Fprintf(os.Stderr, "Error occurred when caching the token: %v\n", err)
+				}
+			}
+		}
+
+		env := os.Environ()
+		if len(strings.TrimSpace(accessTokenEnv)) > 0 {
+			env = append(env, accessTokenEnv+"="+token.AccessToken)
+		}
+		if len(strings.TrimSpace(idTokenEnv)) > 0 && len(token.IDToken) > 0 {
+			env = append(env, idTokenEnv+"="+token.IDToken)
+		}
+		if len(strings.TrimSpace(expiresAtEnv)) > 0 {
+			env = append(env, expiresAtEnv+"="+token.ExpiresAt.UTC().Format(time.RFC3339))
+		}
+		os.Exit(runChild(command, env))
+	}
+}
+
+// Vends a token, taking the tokens from the token hook, the same ones written to the sinks.
+func (f *vendFlags) vendForExec() (*cachedToken, error) {
+
+	var received *vendor.TokenEvent
+	oktv := vendor.NewTokenVendor(append(f.options(), vendor.OnToken(func(event vendor.TokenEvent) {
+		received = &event
+	})))
+	if _, err := f.vend(oktv); err != nil {
+		return nil, err
+	}
+	if received == nil {
+		return nil, fmt.Errorf("failed to retrieve the ACCESS TOKEN")
+	}
+	return &cachedToken{AccessTokenResponse: *received.Token, ExpiresAt: received.ExpiresAt}, nil
+}
+
+// Runs the command with the environment, forwarding the signals received to it, and returns
+// its exit code. Commands killed by a signal exit with 128 plus the signal number, like shells.
+func runChild(command []string, env []string) int {
+
+	child := exec.Command(command[0], command[1:]...)
+	child.Env = env
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+
+	if err := child.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error occurred when running the command: %v\n", err)
+		return 127
+	}
+	go func() {
+		for received := range signals {
+			child.Process.Signal(received)
+		}
+	}()
+
+	err := child.Wait()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	fmt.Fprintf(os.Stderr, "Error occurred when running the command: %v\n", err)
+	return 1
+}
@@ -1,3 +1,7 @@
 module github.com/js10x/okta-token-vendor
 
 go 1.17
+
+require software.sslmate.com/src/go-pkcs12 v0.2.0
+
+require golang.org/x/crypto v0.0.0-20220331220935-ae2d96664a29 // indirect
@@ -1,93 +1,417 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/js10x/okta-token-vendor/pkce"
+	"github.com/js10x/okta-token-vendor/qrcode"
 	"github.com/js10x/okta-token-vendor/vendor"
 )
 
+// How long to wait for the user to finish logging in through the browser.
+const browserLoginTimeout = 5 * time.Minute
+
 func main() {
 
-	var username, password, cid, iss, callback, out string
-	var validConfig bool = false
+	// Without a command, the token is vended as before, e.g. oktv -user "abc" -pw "abc" ...
+	name, args := "get", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command [%v], run oktv help to list the commands.\n", name)
+		os.Exit(2)
+	}
+	fs := cmd.flagSet()
+	cmd.setup(fs)(args)
+}
+
+// Vends a token with the configured flow and prints it.
+func setupGet(fs *flag.FlagSet) func(args []string) {
+
+	var f vendFlags
+	var dryRun bool
+	var dryRunFormat string
+	f.register(fs)
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the requests the flow would make, with secrets redacted, instead of making them.")
+	fs.StringVar(&dryRunFormat, "dry-run-format", dryRunText, "The format of the -dry-run output: text, or curl for a script replaying the requests.")
+	return func(args []string) {
+		if err := f.parse(fs, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		oktv := vendor.NewTokenVendor(f.options())
+		if dryRun {
+			f.dryRun(oktv, dryRunFormat)
+			return
+		}
+		accessToken, err := f.vend(oktv)
+		f.saveHAR()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		f.printToken(accessToken)
+	}
+}
+
+// Prints the requests the flow would make, without making any of them.
+func (f *vendFlags) dryRun(oktv *vendor.TokenVendor, format string) {
+	if format != dryRunText && format != dryRunCurl {
+		fmt.Fprintf(os.Stderr, "You must specify a -dry-run-format of text or curl\n")
+		os.Exit(1)
+	}
+	if err := f.validate(oktv); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	planned, err := oktv.DryRun()
+	if err == nil {
+		err = printDryRun(os.Stdout, planned, format)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// Prints the token, unless the -template prints it instead, and exits with an error when it
+// could not be written to one of the sinks.
+func (f *vendFlags) printToken(accessToken *vendor.AccessTokenResponse) {
+	if len(f.output.template) == 0 {
+		fmt.Println(accessToken.ToString())
+	}
+	if f.outputFailed {
+		os.Exit(exitOutputFailed)
+	}
+}
 
-	flag.StringVar(&username, "user", "The username associated with your Okta application.", "abc")
-	flag.StringVar(&password, "pw", "The password associated with your Okta application.", "abc")
-	flag.StringVar(&cid, "cid", "", "The client ID configured for your Okta application.")
-	flag.StringVar(&iss, "iss", "", "The ISSUER configured for your Okta application.")
-	flag.StringVar(&callback, "callback", "", "One of the configured REDIRECT URIs configured in your Okta application.")
-	flag.StringVar(&out, "o", "", "Print the access token to the provided file.")
-	flag.Parse()
+// Flags shared by every command that vends a token.
+type vendFlags struct {
+	username, password, cid, secret, iss, callback, scope, responseType, responseMode, out, harPath, flow, dpopKeyPath, certPath, certKeyPath, certPassword, caBundle, proxy, minTLS, profile, configPath, pkceMethod string
+	retries, pkceVerifierLength                                                                                                                                                                                       int
+	verbose, veryVerbose, browser, qr, par, dpop, insecure                                                                                                                                                            bool
+
+	recorder *vendor.HARRecorder
+	dpopKey  *vendor.DPoPKey
+	dpopErr  error
+	cert     *tls.Certificate
+	certErr  error
+	tlsErr   error
+	output   sinkFlags
+	sinks    []tokenSink
+	sinkErr  error
+	// Set when the token could not be written to one of the sinks.
+	outputFailed bool
+}
+
+func (f *vendFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.username, "user", "", "The username associated with your Okta application.")
+	fs.StringVar(&f.password, "pw", "", "The password associated with your Okta application.")
+	fs.StringVar(&f.cid, "cid", "", "The client ID configured for your Okta application.")
+	fs.StringVar(&f.secret, "secret", "", "The client secret of your Okta application, for confidential clients.")
+	fs.StringVar(&f.iss, "iss", "", "The ISSUER configured for your Okta application.")
+	fs.StringVar(&f.callback, "callback", "", "One of the configured REDIRECT URIs configured in your Okta application.")
+	fs.StringVar(&f.scope, "scope", "openid", "Space separated scopes to request.")
+	fs.StringVar(&f.responseType, "response-type", "code", "The response type of the pkce flow. The implicit (token, id_token) and hybrid (code id_token, ...) response types are legacy.")
+	fs.StringVar(&f.responseMode, "response-mode", "", "How the authorization response is returned by the pkce flow: query, fragment, form_post or okta_post_message.")
+	fs.StringVar(&f.out, "o", "", "Print the access token to the provided file.")
+	fs.IntVar(&f.retries, "retries", 3, "How many times a failed or rate limited request is retried.")
+	fs.StringVar(&f.flow, "flow", vendor.FlowPKCE, "The flow used to get the token: pkce, browser, device or password.")
+	fs.BoolVar(&f.browser, "browser", false, "Log in through the system browser instead of with -user and -pw. The -callback must be a loopback address. Same as -flow browser.")
+	fs.StringVar(&f.pkceMethod, "pkce-method", pkce.ChallengeMethodS256, "How the PKCE code challenge is derived from the code verifier: S256, or plain for testing servers that require it.")
+	fs.IntVar(&f.pkceVerifierLength, "pkce-verifier-length", pkce.DefaultVerifierLength, "The length of the PKCE code verifier, between 43 and 128 characters.")
+	fs.BoolVar(&f.par, "par", false, "Push the authorization request to the PAR endpoint found with discovery, sending only the client ID and request URI to /authorize.")
+	fs.BoolVar(&f.dpop, "dpop", false, "Bind the token to a DPoP key, saved to -dpop-key so that proofs can be minted with the dpop-proof command.")
+	fs.StringVar(&f.dpopKeyPath, "dpop-key", "", "The DPoP key file, reused when it exists. Defaults to the -o file with a .dpop-key.pem extension.")
+	fs.StringVar(&f.certPath, "cert", "", "The client certificate for mutual TLS, either a PEM file (with -cert-key) or a PKCS #12 .p12/.pfx file (with -cert-password).")
+	fs.StringVar(&f.certKeyPath, "cert-key", "", "The PEM private key of the -cert client certificate.")
+	fs.StringVar(&f.certPassword, "cert-password", "", "The password of the -cert PKCS #12 file.")
+	fs.StringVar(&f.caBundle, "ca-bundle", "", "A PEM file of CA certificates to trust on top of the system roots, e.g. the root of a TLS inspecting proxy.")
+	fs.StringVar(&f.proxy, "proxy", "", "An http, https or socks5 proxy URL to send every request through, instead of HTTP_PROXY and HTTPS_PROXY.")
+	fs.StringVar(&f.minTLS, "min-tls", "", "The minimum TLS version accepted: 1.0, 1.1, 1.2 or 1.3.")
+	fs.BoolVar(&f.insecure, "insecure-skip-verify", false, "INSECURE: do not verify Okta's TLS certificate. Only for local mock servers with self-signed certificates.")
+	fs.StringVar(&f.profile, "profile", defaultProfile, "The profile of the config file to take the flags not given on the command line from.")
+	fs.StringVar(&f.configPath, "config", defaultConfigPath(), "The config file holding the profiles.")
+	fs.BoolVar(&f.qr, "qr", false, "Also print the verification URI of the device flow as a QR code.")
+	fs.StringVar(&f.harPath, "har", "", "Record every request and response made during the run into the provided HAR file.")
+	fs.BoolVar(&f.verbose, "v", false, "Trace each request made to Okta to stderr, with secrets redacted.")
+	fs.BoolVar(&f.veryVerbose, "vv", false, "Like -v, but also trace the headers and bodies of each request.")
+	f.output.register(fs)
+}
+
+// Builds the options for the token vendor from the flags.
+func (f *vendFlags) options() []vendor.Option {
+
+	verbosity := vendor.TraceOff
+	switch {
+	case f.veryVerbose:
+		verbosity = vendor.TraceBodies
+	case f.verbose:
+		verbosity = vendor.TraceRequests
+	}
+
+	if f.browser {
+		f.flow = vendor.FlowBrowser
+	}
 
 	ops := []vendor.Option{
-		vendor.ClientID(cid),
-		vendor.Issuer(iss),
-		vendor.RedirectURI(callback),
-		vendor.OnTokenReceived(func(accessToken string) {
-			if len(strings.TrimSpace(out)) <= 0 {
-				return
+		vendor.Flow(f.flow),
+		vendor.Credentials(f.username, f.password),
+		vendor.ClientID(f.cid),
+		vendor.ClientSecret(f.secret),
+		vendor.Issuer(f.iss),
+		vendor.RedirectURI(f.callback),
+		vendor.Scope(f.scope),
+		vendor.ResponseType(f.responseType),
+		vendor.ResponseMode(f.responseMode),
+		vendor.PAR(f.par),
+		vendor.CodeChallengeMethod(f.pkceMethod),
+		vendor.CodeVerifierLength(f.pkceVerifierLength),
+		vendor.MaxRetries(f.retries),
+		vendor.Verbosity(verbosity),
+		vendor.Browser(func(authorizeURL string) error {
+			fmt.Fprintf(os.Stderr, "Opening the browser to log in. If it does not open, visit:\n\n%v\n\n", authorizeURL)
+			if err := vendor.OpenBrowser(authorizeURL); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to open the browser: %v\n", err)
 			}
-			// Write the access token to the provided file, if the user asked for it.
-			file, err := os.Create(out)
-			if err != nil {
-				fmt.Printf("Error occurred when creating the output file provided: %v\n", err)
-			} else {
-				file.WriteString(accessToken)
+			return nil
+		}),
+		vendor.OnDeviceAuthorization(func(device *vendor.DeviceAuthorizationResponse) {
+			verificationURI := device.VerificationURIComplete
+			if len(verificationURI) == 0 {
+				verificationURI = device.VerificationURI
+			}
+			fmt.Fprintf(os.Stderr, "To log in, visit the following URL on any device and confirm the code [%v]:\n\n%v\n\n", device.UserCode, verificationURI)
+			if f.qr {
+				code, err := qrcode.Encode(verificationURI)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to render the QR code: %v\n", err)
+					return
+				}
+				fmt.Fprintf(os.Stderr, "%v\n", code.Terminal())
 			}
-			file.Close()
 		}),
+		vendor.OnToken(func(event vendor.TokenEvent) {
+			// Write the tokens wherever the user asked for them.
+			if !f.output.write(f.sinks, event) {
+				f.outputFailed = true
+			}
+		}),
+	}
+	if f.sinks == nil && f.sinkErr == nil {
+		f.sinks, f.sinkErr = f.output.sinks()
+		if len(strings.TrimSpace(f.out)) > 0 {
+			// The file is replaced atomically, so that readers never see a partially written token.
+			f.sinks = append([]tokenSink{{name: "output file", path: f.out, write: func(token sinkToken) error {
+				return vendor.WriteFileAtomic(f.out, []byte(token.AccessToken))
+			}}}, f.sinks...)
+		}
+	}
+	if f.dpop {
+		if f.dpopKey == nil && f.dpopErr == nil {
+			f.dpopKey, f.dpopErr = loadOrGenerateDPoPKey(f.keyPath())
+		}
+		ops = append(ops, vendor.DPoP(f.dpopKey))
+	}
+	if len(strings.TrimSpace(f.certPath)) > 0 {
+		if f.cert == nil && f.certErr == nil {
+			f.cert, f.certErr = f.loadCertificate()
+		}
+		ops = append(ops, vendor.ClientCertificate(f.cert))
+	}
+	if len(strings.TrimSpace(f.minTLS)) > 0 {
+		version, err := vendor.ParseTLSVersion(f.minTLS)
+		if err != nil {
+			f.tlsErr = err
+		}
+		ops = append(ops, vendor.MinTLSVersion(version))
+	}
+	ops = append(ops,
+		vendor.CABundle(f.caBundle),
+		vendor.Proxy(f.proxy),
+		vendor.InsecureSkipVerify(f.insecure),
+	)
+	if len(strings.TrimSpace(f.harPath)) > 0 {
+		if f.recorder == nil {
+			f.recorder = vendor.NewHARRecorder()
+		}
+		ops = append(ops, vendor.HAR(f.recorder))
 	}
-	oktv := vendor.NewTokenVendor(ops)
+	return ops
+}
+
+// Validates the configuration, then runs the configured flow.
+func (f *vendFlags) vend(oktv *vendor.TokenVendor) (*vendor.AccessTokenResponse, error) {
+
+	if err := f.validate(oktv); err != nil {
+		return nil, err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if oktv.Ops.Flow == vendor.FlowBrowser {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, browserLoginTimeout)
+		defer cancel()
+	}
+	accessToken, err := oktv.Vend(ctx)
+	if err == nil && f.dpopKey != nil {
+		f.saveDPoPKey()
+	}
+	if err == nil && f.cert != nil {
+		reportCertificateBinding(accessToken, f.cert)
+	}
+	return accessToken, err
+}
+
+// Validates the configuration before running any flow.
+func (f *vendFlags) validate(oktv *vendor.TokenVendor) error {
 
 	switch {
 
 	// Validate User ID and PW
-	case len(strings.TrimSpace(username)) <= 0, len(strings.TrimSpace(password)) <= 0:
-		fmt.Fprintf(os.Stderr, "You must specify both your username and password\n")
+	case (oktv.Ops.Flow == vendor.FlowPKCE || oktv.Ops.Flow == vendor.FlowPassword) && (len(strings.TrimSpace(oktv.Ops.Username)) <= 0 || len(strings.TrimSpace(oktv.Ops.Password)) <= 0):
+		return fmt.Errorf("You must specify both your username and password")
 
 	// Validate Client ID
 	case len(strings.TrimSpace(oktv.Ops.ClientID)) <= 0:
-		fmt.Fprintf(os.Stderr, "You must specify a CLIENT ID\n")
+		return fmt.Errorf("You must specify a CLIENT ID")
 
 	// Validate Issuer
 	case len(strings.TrimSpace(oktv.Ops.Issuer)) <= 0:
-		fmt.Fprintf(os.Stderr, "You must specify an ISSUER\n")
+		return fmt.Errorf("You must specify an ISSUER")
+
+	// Validate TLS settings
+	case f.tlsErr != nil:
+		return f.tlsErr
+
+	// Validate output sinks
+	case f.sinkErr != nil:
+		return f.sinkErr
+
+	// Validate client certificate
+	case f.certErr != nil:
+		return fmt.Errorf("Error occurred when loading the client certificate: %v", f.certErr)
+
+	// Validate DPoP key
+	case f.dpopErr != nil:
+		return fmt.Errorf("Error occurred when loading the DPoP key: %v", f.dpopErr)
+
+	// Validate PKCE settings
+	case f.pkceVerifierLength < pkce.MinVerifierLength || f.pkceVerifierLength > pkce.MaxVerifierLength:
+		return fmt.Errorf("You must specify a -pkce-verifier-length between %v and %v", pkce.MinVerifierLength, pkce.MaxVerifierLength)
+	case f.pkceMethod != pkce.ChallengeMethodS256 && f.pkceMethod != pkce.ChallengeMethodPlain:
+		return fmt.Errorf("You must specify a -pkce-method of %v or %v", pkce.ChallengeMethodS256, pkce.ChallengeMethodPlain)
 
 	// Validate Redirect URI
-	case len(strings.TrimSpace(oktv.Ops.RedirectURI)) <= 0:
-		fmt.Fprintf(os.Stderr, "You must specify a Redirect URI\n")
+	case oktv.Ops.Flow != vendor.FlowDevice && oktv.Ops.Flow != vendor.FlowPassword && len(strings.TrimSpace(oktv.Ops.RedirectURI)) <= 0:
+		return fmt.Errorf("You must specify a Redirect URI")
+	}
+	// Catch malformed URLs here, instead of as opaque errors from Okta later on.
+	for _, check := range oktv.CheckConfig() {
+		if check.Status == vendor.CheckFail {
+			return fmt.Errorf("Invalid %v: %v", strings.ToUpper(check.Name), check.Detail)
+		}
+	}
+	if oktv.Ops.Flow == vendor.FlowPKCE && (pkce.HasResponseType(oktv.Ops.ResponseType, "token") || pkce.HasResponseType(oktv.Ops.ResponseType, "id_token")) {
+		fmt.Fprintf(os.Stderr, "WARNING: The [%v] response type is legacy and returns tokens through the browser. Only use it to test older apps.\n", oktv.Ops.ResponseType)
+	}
+	if (oktv.Ops.Flow == vendor.FlowPKCE || oktv.Ops.Flow == vendor.FlowBrowser) && f.pkceMethod == pkce.ChallengeMethodPlain {
+		fmt.Fprintf(os.Stderr, "WARNING: The plain PKCE method sends the code verifier itself as the challenge. Only use it to test servers that require it.\n")
+	}
+	if oktv.Ops.InsecureSkipVerify {
+		fmt.Fprintf(os.Stderr, "WARNING: TLS certificate verification is DISABLED. Anyone on the network can impersonate Okta and capture your credentials. Only use -insecure-skip-verify with local mock servers.\n")
+	}
+	fmt.Fprintf(os.Stderr, "Configuration Accepted => Let's go get you a token.\n")
+	return nil
+}
 
-	default:
-		validConfig = true
+// Writes the HAR file, if one was asked for.
+func (f *vendFlags) saveHAR() {
+	if f.recorder == nil {
+		return
 	}
+	if err := f.recorder.WriteFile(f.harPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Error occurred when writing the HAR file: %v\n", err)
+	}
+}
 
-	if !validConfig {
-		os.Exit(0)
+// Returns where the DPoP key is kept, next to the -o file unless given with -dpop-key.
+func (f *vendFlags) keyPath() string {
+	if len(strings.TrimSpace(f.dpopKeyPath)) > 0 {
+		return f.dpopKeyPath
 	}
-	fmt.Fprintf(os.Stdout, "Configuration Accepted => Let's go get you a token.\n")
+	if len(strings.TrimSpace(f.out)) > 0 {
+		return f.out + ".dpop-key.pem"
+	}
+	return ""
+}
 
-	// 1.) Get the session token
-	sessionToken, err := oktv.GetSessionToken(username, password)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error occurred when fetching the SESSION TOKEN: %v\n", err)
-		os.Exit(0)
+// Writes the DPoP key bound to the token, so that proofs can be minted for it later.
+func (f *vendFlags) saveDPoPKey() {
+	path := f.keyPath()
+	if len(path) == 0 {
+		fmt.Fprintf(os.Stderr, "The DPoP key was not saved, pass -dpop-key or -o to mint proofs for the token later.\n")
+		return
 	}
+	if err := f.dpopKey.Save(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Error occurred when writing the DPoP key: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "DPoP key saved to [%v]\n", path)
+}
 
-	// 2.) Get the authorization code using the session token
-	authCode, err := oktv.GetAuthorizationCode(sessionToken.Token)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error occurred when fetching the AUTHORIZATION TOKEN: %v\n", err)
-		os.Exit(0)
+// Reuses the DPoP key at the path if there is one, so that tokens vended again stay bound to it.
+func loadOrGenerateDPoPKey(path string) (*vendor.DPoPKey, error) {
+	if len(path) > 0 {
+		if _, err := os.Stat(path); err == nil {
+			return vendor.LoadDPoPKey(path)
+		}
 	}
+	return vendor.GenerateDPoPKey()
+}
 
-	// 3.) Get the access token using the authorization code
-	accessToken, err := oktv.GetAccessToken(authCode.CodeVerifier, authCode.Code)
+// Loads the -cert client certificate, telling PKCS #12 and PEM files apart by their extension.
+func (f *vendFlags) loadCertificate() (*tls.Certificate, error) {
+	var cert tls.Certificate
+	var err error
+	switch strings.ToLower(filepath.Ext(f.certPath)) {
+	case ".p12", ".pfx":
+		cert, err = vendor.LoadPKCS12(f.certPath, f.certPassword)
+	default:
+		keyPath := f.certKeyPath
+		if len(strings.TrimSpace(keyPath)) == 0 {
+			// The key can be in the same PEM file as the certificate.
+			keyPath = f.certPath
+		}
+		cert, err = vendor.LoadClientCertificate(f.certPath, keyPath)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error occurred when fetching the ACCESS TOKEN: %v\n", err)
-		os.Exit(0)
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// Reports whether the token is bound to the client certificate, as the cnf.x5t#S256 claim says.
+func reportCertificateBinding(accessToken *vendor.AccessTokenResponse, cert *tls.Certificate) {
+	bound := vendor.BoundCertificateThumbprint(accessToken.AccessToken)
+	switch {
+	case len(bound) == 0:
+		fmt.Fprintf(os.Stderr, "The token is not bound to the client certificate.\n")
+	case bound != vendor.CertificateThumbprint(*cert):
+		fmt.Fprintf(os.Stderr, "WARNING: The token is bound to a different certificate, cnf.x5t#S256 [%v]\n", bound)
+	default:
+		fmt.Fprintf(os.Stderr, "The token is bound to the client certificate, cnf.x5t#S256 [%v]\n", bound)
 	}
-	fmt.Println(accessToken.ToString())
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/js10x/okta-token-vendor/oktatest"
+)
+
+// Runs a fake Okta locally, so that applications can be pointed at it for offline testing.
+func setupMockServer(fs *flag.FlagSet) func(args []string) {
+
+	var addr, cid, secret, username, password, callback, usersFile, authServer string
+	var latency time.Duration
+	var requirePAR, requireDPoP, requireDPoPNonce bool
+
+	fs.StringVar(&addr, "addr", "127.0.0.1:8080", "The address the mock server listens on.")
+	fs.StringVar(&cid, "cid", "", "The client ID of the mock application. Any client ID is accepted when empty.")
+	fs.StringVar(&secret, "secret", "", "The client secret of the mock application, for confidential clients.")
+	fs.StringVar(&username, "user", "user", "The username of a user that can log in.")
+	fs.StringVar(&password, "pw", "password", "The password of the user that can log in.")
+	fs.StringVar(&usersFile, "users", "", "A JSON file of additional users, keyed by username, e.g. {\"alice\": {\"password\": \"pw\", \"factors\": [\"push\"]}}.")
+	fs.StringVar(&callback, "callback", "", "Comma separated REDIRECT URIs accepted by the mock application. Any redirect URI is accepted when empty.")
+	fs.StringVar(&authServer, "as", "default", "The ID of the mock authorization server.")
+	fs.DurationVar(&latency, "latency", 0, "A delay added to every response, e.g. 250ms.")
+	fs.BoolVar(&requirePAR, "require-par", false, "Reject authorization requests that were not pushed to the PAR endpoint first.")
+	fs.BoolVar(&requireDPoP, "require-dpop", false, "Reject token requests without a DPoP proof.")
+	fs.BoolVar(&requireDPoPNonce, "require-dpop-nonce", false, "Reject DPoP proofs without the server nonce.")
+	return func(args []string) {
+		fs.Parse(args)
+
+		users := map[string]oktatest.User{}
+		if len(strings.TrimSpace(usersFile)) > 0 {
+			content, err := ioutil.ReadFile(usersFile)
+			if err == nil {
+				err = json.Unmarshal(content, &users)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error occurred when reading the users file: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if len(strings.TrimSpace(username)) > 0 {
+			users[username] = oktatest.User{Password: password}
+		}
+
+		var redirectURIs []string
+		for _, uri := range strings.Split(callback, ",") {
+			if len(strings.TrimSpace(uri)) > 0 {
+				redirectURIs = append(redirectURIs, strings.TrimSpace(uri))
+			}
+		}
+
+		okta := oktatest.New(oktatest.Config{
+			ClientID:              cid,
+			ClientSecret:          secret,
+			RedirectURIs:          redirectURIs,
+			AuthorizationServerID: authServer,
+			Users:                 users,
+			Latency:               latency,
+			RequirePAR:            requirePAR,
+			RequireDPoP:           requireDPoP,
+			RequireDPoPNonce:      requireDPoPNonce,
+		})
+
+		fmt.Fprintf(os.Stderr, "Mock Okta listening => ISSUER [http://%v/oauth2/%v]\n", addr, authServer)
+		if err := http.ListenAndServe(addr, okta); err != nil {
+			fmt.Fprintf(os.Stderr, "Error occurred when running the mock server: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package oktatest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// How far the iat claim of a DPoP proof may be from the current time.
+const dpopProofWindow = time.Minute
+
+// The proof did not carry the current nonce, which is returned in the DPoP-Nonce header.
+var errDPoPNonce = errors.New("Authorization server requires nonce in DPoP proof.")
+
+// Verifies the DPoP proof sent with the request, returning the JWK thumbprint of its key.
+func (o *Okta) verifyDPoP(r *http.Request, proof string) (string, error) {
+
+	parts := strings.Split(proof, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("The DPoP proof is not a JWT.")
+	}
+
+	var header struct {
+		Typ string            `json:"typ"`
+		Alg string            `json:"alg"`
+		JWK map[string]string `json:"jwk"`
+	}
+	var claims struct {
+		JTI   string `json:"jti"`
+		HTM   string `json:"htm"`
+		HTU   string `json:"htu"`
+		IAT   int64  `json:"iat"`
+		Nonce string `json:"nonce"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", fmt.Errorf("The DPoP proof header is not valid JSON.")
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", fmt.Errorf("The DPoP proof claims are not valid JSON.")
+	}
+
+	if header.Typ != "dpop+jwt" || header.Alg != "ES256" || header.JWK["kty"] != "EC" || header.JWK["crv"] != "P-256" {
+		return "", fmt.Errorf("The DPoP proof must be an ES256 dpop+jwt with a P-256 jwk.")
+	}
+	x, errX := base64.RawURLEncoding.DecodeString(header.JWK["x"])
+	y, errY := base64.RawURLEncoding.DecodeString(header.JWK["y"])
+	signature, errS := base64.RawURLEncoding.DecodeString(parts[2])
+	if errX != nil || errY != nil || errS != nil || len(signature) != 64 {
+		return "", fmt.Errorf("The DPoP proof jwk or signature is malformed.")
+	}
+	key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		return "", fmt.Errorf("The DPoP proof signature is invalid.")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	issued := time.Unix(claims.IAT, 0)
+	switch {
+	case claims.HTM != r.Method:
+		return "", fmt.Errorf("The DPoP proof 'htm' does not match the request method.")
+	case claims.HTU != scheme+"://"+r.Host+r.URL.Path:
+		return "", fmt.Errorf("The DPoP proof 'htu' does not match the request URL.")
+	case time.Since(issued) > dpopProofWindow || time.Until(issued) > dpopProofWindow:
+		return "", fmt.Errorf("The DPoP proof 'iat' is outside of the accepted window.")
+	case len(claims.JTI) == 0:
+		return "", fmt.Errorf("The DPoP proof 'jti' is missing.")
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.config.RequireDPoPNonce && claims.Nonce != o.dpopNonce {
+		return "", errDPoPNonce
+	}
+	if _, replayed := o.proofs[claims.JTI]; replayed {
+		return "", fmt.Errorf("The DPoP proof 'jti' has already been used.")
+	}
+	o.proofs[claims.JTI] = issued
+
+	canonical := fmt.Sprintf(`{"crv":"%v","kty":"%v","x":"%v","y":"%v"}`, header.JWK["crv"], header.JWK["kty"], header.JWK["x"], header.JWK["y"])
+	thumbprint := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(thumbprint[:]), nil
+}
+
+// Checks the DPoP proof of a /token request, writing the error response when it is missing
+// (and required) or invalid. Returns the thumbprint of the key, empty for Bearer tokens.
+func (o *Okta) tokenDPoP(w http.ResponseWriter, r *http.Request) (string, bool) {
+
+	proof := r.Header.Get("DPoP")
+	if len(proof) == 0 {
+		if o.config.RequireDPoP {
+			oauthError(w, http.StatusBadRequest, "invalid_dpop_proof", "The DPoP proof JWT header is missing.")
+			return "", false
+		}
+		return "", true
+	}
+
+	jkt, err := o.verifyDPoP(r, proof)
+	if err == errDPoPNonce {
+		w.Header().Set("DPoP-Nonce", o.dpopNonce)
+		oauthError(w, http.StatusBadRequest, "use_dpop_nonce", err.Error())
+		return "", false
+	}
+	if err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_dpop_proof", err.Error())
+		return "", false
+	}
+	return jkt, true
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, v)
+}
@@ -0,0 +1,60 @@
+package oktatest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+)
+
+// JSON Web Key as served from the /keys endpoint.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func publicJWK(key *rsa.PrivateKey, kid string) jsonWebKey {
+	return jsonWebKey{
+		Kty: "RSA",
+		Alg: "RS256",
+		Use: "sig",
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+	}
+}
+
+// Signs the claims as an RS256 JSON Web Token.
+func signJWT(key *rsa.PrivateKey, kid string, claims map[string]interface{}) (string, error) {
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Creates a random opaque value, used for session tokens, codes and identifiers.
+func randomString(size int) string {
+	bytes := make([]byte, size)
+	rand.Read(bytes)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
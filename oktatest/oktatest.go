@@ -0,0 +1,1105 @@
+// Package oktatest provides an in-process fake of the Okta APIs used by the token vendor, for
+// testing Okta integrations offline. It implements the authn API, the /authorize endpoint
+// (redirecting back with a code, or serving a login form), the /token endpoint with real PKCE
+// verification, and the /par, /keys, discovery, userinfo, introspect and revoke endpoints of an
+// authorization server. Tokens are RS256 signed JWTs that verify against the served keys.
+package oktatest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/js10x/okta-token-vendor/pkce"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// User that can log in to the fake Okta.
+type User struct {
+	Password string `json:"password"`
+	// Status reported by the authn API instead of SUCCESS, e.g. LOCKED_OUT or PASSWORD_EXPIRED.
+	Status string `json:"status,omitempty"`
+	// Factors the user is enrolled in, e.g. "push". Users with factors get MFA_REQUIRED from
+	// the authn API, since the fake does not implement factor verification.
+	Factors []string `json:"factors,omitempty"`
+	// Additional claims included in the ID token and userinfo response.
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// Config of the fake Okta.
+type Config struct {
+	// Client ID of the application. Any client ID is accepted when empty.
+	ClientID string
+	// Client secret of the application. Public clients (no secret) are assumed when empty.
+	ClientSecret string
+	// Redirect URIs registered for the application. Any redirect URI is accepted when empty.
+	RedirectURIs []string
+	// ID of the custom authorization server, "default" when empty.
+	AuthorizationServerID string
+	// Users that can log in, keyed by username.
+	Users map[string]User
+	// Lifetime of the issued access and ID tokens, one hour when zero.
+	TokenLifetime time.Duration
+	// Delay added to every response, to simulate a slow network.
+	Latency time.Duration
+	// Rejects authorization requests that were not pushed to the PAR endpoint first.
+	RequirePAR bool
+	// Rejects /token requests without a DPoP proof, so that only DPoP bound tokens are issued.
+	RequireDPoP bool
+	// Rejects DPoP proofs without the server nonce, replying with use_dpop_nonce.
+	RequireDPoPNonce bool
+	// Authenticates the client with its TLS certificate (tls_client_auth) instead of a secret,
+	// binding the tokens to the certificate. Requires NewTLSServer, and the client to use the
+	// mutual TLS endpoint aliases published with discovery.
+	TLSClientAuth bool
+	// CAs that client certificates must chain to, for NewTLSServer.
+	ClientCAs *x509.CertPool
+}
+
+// Okta is an http.Handler implementing a fake Okta org with a single authorization server.
+type Okta struct {
+	config Config
+	key    *rsa.PrivateKey
+	kid    string
+
+	mu       sync.Mutex
+	latency  time.Duration
+	sessions map[string]string
+	codes    map[string]*authorization
+	pushed   map[string]*pushedRequest
+	tokens   map[string]*grant
+	faults   map[string][]fault
+
+	dpopNonce string
+	proofs    map[string]time.Time
+}
+
+// Server is a fake Okta listening on a loopback address.
+type Server struct {
+	*httptest.Server
+	Okta *Okta
+}
+
+// A pending authorization code.
+type authorization struct {
+	username      string
+	clientID      string
+	redirectURI   string
+	scope         string
+	nonce         string
+	challenge     string
+	challengeType string
+	expires       time.Time
+}
+
+// The parameters of an authorization request pushed to the PAR endpoint.
+type pushedRequest struct {
+	params  url.Values
+	expires time.Time
+}
+
+// An issued access or refresh token.
+type grant struct {
+	username string
+	clientID string
+	scope    string
+	// Thumbprint of the DPoP key the token is bound to, empty for Bearer tokens.
+	jkt     string
+	refresh bool
+	revoked bool
+	issued  time.Time
+	expires time.Time
+}
+
+// What to issue in a token response.
+type issuance struct {
+	username string
+	clientID string
+	scope    string
+	nonce    string
+	// Audience of the access token, the authorization server itself when empty.
+	audience string
+	// Token exchange only issues an access token.
+	exchanged bool
+	// Tokens returned by the implicit and hybrid response types never include a refresh token.
+	frontChannel bool
+	// Thumbprint of the DPoP key to bind the tokens to, empty for Bearer tokens.
+	jkt string
+}
+
+type fault struct {
+	status int
+	body   string
+}
+
+// Creates a fake Okta with a freshly generated signing key.
+func New(config Config) *Okta {
+	if len(config.AuthorizationServerID) == 0 {
+		config.AuthorizationServerID = "default"
+	}
+	if config.TokenLifetime <= 0 {
+		config.TokenLifetime = time.Hour
+	}
+	if config.Users == nil {
+		config.Users = map[string]User{}
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("oktatest: failed to generate a signing key: %v", err))
+	}
+	return &Okta{
+		config:   config,
+		key:      key,
+		kid:      randomString(8),
+		latency:  config.Latency,
+		sessions: map[string]string{},
+		codes:    map[string]*authorization{},
+		pushed:   map[string]*pushedRequest{},
+		proofs:   map[string]time.Time{},
+		tokens:   map[string]*grant{},
+		faults:   map[string][]fault{},
+
+		dpopNonce: randomString(16),
+	}
+}
+
+// Starts a fake Okta on a loopback address. Close it when done.
+func NewServer(config Config) *Server {
+	okta := New(config)
+	return &Server{Server: httptest.NewServer(okta), Okta: okta}
+}
+
+// Starts a fake Okta serving HTTPS on a loopback address, asking for client certificates
+// issued by Config.ClientCAs. Trust it with the pool returned by Server.Certificate. Close it
+// when done.
+func NewTLSServer(config Config) *Server {
+	okta := New(config)
+	server := httptest.NewUnstartedServer(okta)
+	server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: config.ClientCAs}
+	server.StartTLS()
+	return &Server{Server: server, Okta: okta}
+}
+
+// Returns the issuer URL of the authorization server, for use with vendor.Issuer.
+func (s *Server) Issuer() string {
+	return s.URL + "/oauth2/" + s.Okta.config.AuthorizationServerID
+}
+
+// Makes the next request to the given path (e.g. "/api/v1/authn" or
+// "/oauth2/default/v1/token") fail with the given status code and JSON body.
+func (o *Okta) InjectError(path string, status int, body string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.faults[path] = append(o.faults[path], fault{status: status, body: body})
+}
+
+// Sets the delay added to every response.
+func (o *Okta) SetLatency(latency time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.latency = latency
+}
+
+func (o *Okta) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	o.mu.Lock()
+	latency := o.latency
+	var injected *fault
+	if faults := o.faults[r.URL.Path]; len(faults) > 0 {
+		injected = &faults[0]
+		o.faults[r.URL.Path] = faults[1:]
+	}
+	o.mu.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if injected != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(injected.status)
+		io.WriteString(w, injected.body)
+		return
+	}
+
+	if r.URL.Path == "/api/v1/authn" {
+		o.authn(w, r)
+		return
+	}
+
+	prefix := "/oauth2/" + o.config.AuthorizationServerID
+	if !strings.HasPrefix(r.URL.Path, prefix+"/") {
+		oktaError(w, http.StatusNotFound, "E0000022", "The endpoint does not support the provided HTTP method")
+		return
+	}
+
+	// The mutual TLS aliases of the endpoints are served under /mtls.
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+	if strings.HasPrefix(path, "/mtls/") {
+		if r.TLS == nil {
+			oktaError(w, http.StatusNotFound, "E0000022", "The endpoint does not support the provided HTTP method")
+			return
+		}
+		path = strings.TrimPrefix(path, "/mtls")
+	}
+
+	switch path {
+	case "/.well-known/openid-configuration", "/.well-known/oauth-authorization-server":
+		o.discovery(w, r)
+	case "/v1/authorize":
+		o.authorize(w, r)
+	case "/v1/par":
+		o.par(w, r)
+	case "/v1/token":
+		o.token(w, r)
+	case "/v1/keys":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []jsonWebKey{publicJWK(o.key, o.kid)}})
+	case "/v1/userinfo":
+		o.userinfo(w, r)
+	case "/v1/introspect":
+		o.introspect(w, r)
+	case "/v1/revoke":
+		o.revoke(w, r)
+	default:
+		oktaError(w, http.StatusNotFound, "E0000022", "The endpoint does not support the provided HTTP method")
+	}
+}
+
+func (o *Okta) issuer(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%v://%v/oauth2/%v", scheme, r.Host, o.config.AuthorizationServerID)
+}
+
+// POST /api/v1/authn
+func (o *Okta) authn(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		oktaError(w, http.StatusMethodNotAllowed, "E0000022", "The endpoint does not support the provided HTTP method")
+		return
+	}
+
+	var credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+		oktaError(w, http.StatusBadRequest, "E0000003", "The request body was not well-formed.")
+		return
+	}
+
+	user, ok := o.config.Users[credentials.Username]
+	if !ok || subtle.ConstantTimeCompare([]byte(user.Password), []byte(credentials.Password)) != 1 {
+		oktaError(w, http.StatusUnauthorized, "E0000004", "Authentication failed")
+		return
+	}
+
+	expiresAt := time.Now().Add(5 * time.Minute).UTC()
+	switch {
+	case len(user.Status) > 0:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": user.Status, "expiresAt": expiresAt})
+
+	case len(user.Factors) > 0:
+		factors := []map[string]string{}
+		for _, factor := range user.Factors {
+			factors = append(factors, map[string]string{"id": randomString(8), "factorType": factor, "provider": "OKTA"})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":     "MFA_REQUIRED",
+			"stateToken": randomString(16),
+			"expiresAt":  expiresAt,
+			"_embedded":  map[string]interface{}{"factors": factors},
+		})
+
+	default:
+		sessionToken := randomString(24)
+		o.mu.Lock()
+		o.sessions[sessionToken] = credentials.Username
+		o.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       "SUCCESS",
+			"sessionToken": sessionToken,
+			"expiresAt":    expiresAt,
+			"_embedded": map[string]interface{}{
+				"user": map[string]interface{}{
+					"id":      userID(credentials.Username),
+					"profile": map[string]string{"login": credentials.Username},
+				},
+			},
+		})
+	}
+}
+
+// GET /v1/authorize, or POST when the login form is submitted.
+func (o *Okta) authorize(w http.ResponseWriter, r *http.Request) {
+
+	if err := r.ParseForm(); err != nil {
+		oktaError(w, http.StatusBadRequest, "E0000003", "The request was not well-formed.")
+		return
+	}
+	params := r.Form
+
+	// Pushed requests only carry the client ID and request URI on the front channel.
+	if requestURI := params.Get("request_uri"); len(requestURI) > 0 {
+		o.mu.Lock()
+		pushed, ok := o.pushed[requestURI]
+		o.mu.Unlock()
+		if !ok || time.Now().After(pushed.expires) || pushed.params.Get("client_id") != params.Get("client_id") {
+			oktaError(w, http.StatusBadRequest, "invalid_request_uri", "The 'request_uri' is invalid or has expired.")
+			return
+		}
+		params = url.Values{}
+		for name, values := range pushed.params {
+			params[name] = values
+		}
+		params.Set("request_uri", requestURI)
+	} else if o.config.RequirePAR {
+		oktaError(w, http.StatusBadRequest, "invalid_request", "The authorization server requires pushed authorization requests.")
+		return
+	}
+
+	clientID := params.Get("client_id")
+	if len(o.config.ClientID) > 0 && clientID != o.config.ClientID {
+		oktaError(w, http.StatusBadRequest, "invalid_client", "Invalid value for 'client_id' parameter.")
+		return
+	}
+	redirectURI := params.Get("redirect_uri")
+	if !o.validRedirect(redirectURI) {
+		oktaError(w, http.StatusBadRequest, "invalid_request", "The 'redirect_uri' parameter must be a Login redirect URI in the client app settings.")
+		return
+	}
+
+	responseMode := "query"
+	redirectError := func(code string, description string) {
+		respond(w, r, redirectURI, responseMode, url.Values{
+			"error":             {code},
+			"error_description": {description},
+			"state":             {params.Get("state")},
+		})
+	}
+
+	responseTypes, ok := parseResponseType(params.Get("response_type"))
+	if !ok {
+		redirectError("unsupported_response_type", "The response type is not supported by the authorization server.")
+		return
+	}
+	switch responseMode = params.Get("response_mode"); responseMode {
+	case "":
+		// The implicit and hybrid response types return the tokens in the fragment by default.
+		responseMode = "query"
+		if !(len(responseTypes) == 1 && responseTypes["code"]) {
+			responseMode = "fragment"
+		}
+	case "query", "fragment", "form_post", "okta_post_message":
+	default:
+		redirectError("invalid_request", "The 'response_mode' parameter is not supported.")
+		return
+	}
+	challengeType := params.Get("code_challenge_method")
+	if len(params.Get("code_challenge")) > 0 && challengeType != "S256" && challengeType != "plain" {
+		redirectError("invalid_request", "The 'code_challenge_method' parameter must be 'S256' or 'plain'.")
+		return
+	}
+
+	username, ok := o.login(r, params)
+	if !ok {
+		if params.Get("prompt") == "none" {
+			redirectError("login_required", "The client specified not to prompt, but the user is not logged in.")
+			return
+		}
+		loginPage(w, params, r.Method == http.MethodPost)
+		return
+	}
+
+	// Request URIs are single use once the user has logged in.
+	o.mu.Lock()
+	delete(o.pushed, params.Get("request_uri"))
+	o.mu.Unlock()
+
+	result := url.Values{"state": {params.Get("state")}}
+	if responseTypes["code"] {
+		code := randomString(24)
+		o.mu.Lock()
+		o.codes[code] = &authorization{
+			username:      username,
+			clientID:      clientID,
+			redirectURI:   redirectURI,
+			scope:         params.Get("scope"),
+			nonce:         params.Get("nonce"),
+			challenge:     params.Get("code_challenge"),
+			challengeType: challengeType,
+			expires:       time.Now().Add(time.Minute),
+		}
+		o.mu.Unlock()
+		result.Set("code", code)
+	}
+
+	// The implicit and hybrid response types also return the tokens they ask for.
+	if responseTypes["token"] || responseTypes["id_token"] {
+		tokens, err := o.mintTokens(r, issuance{username: username, clientID: clientID, scope: params.Get("scope"), nonce: params.Get("nonce"), frontChannel: true})
+		if err != nil {
+			redirectError("server_error", err.Error())
+			return
+		}
+		if responseTypes["token"] {
+			for _, name := range []string{"access_token", "token_type", "expires_in", "scope"} {
+				result.Set(name, fmt.Sprint(tokens[name]))
+			}
+		}
+		if id, ok := tokens["id_token"].(string); ok && responseTypes["id_token"] {
+			result.Set("id_token", id)
+		}
+	}
+
+	respond(w, r, redirectURI, responseMode, result)
+}
+
+// Parses a space separated response type, reporting whether Okta supports the combination.
+func parseResponseType(responseType string) (map[string]bool, bool) {
+	types := map[string]bool{}
+	for _, t := range strings.Fields(responseType) {
+		if t != "code" && t != "token" && t != "id_token" {
+			return nil, false
+		}
+		types[t] = true
+	}
+	return types, len(types) > 0
+}
+
+// POST /v1/par
+func (o *Okta) par(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		oauthError(w, http.StatusMethodNotAllowed, "invalid_request", "The endpoint only supports POST.")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "The request body was not well-formed.")
+		return
+	}
+	clientID, ok := o.authenticateClient(r)
+	if !ok {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed.")
+		return
+	}
+	params := r.PostForm
+	switch {
+	case len(params.Get("request_uri")) > 0:
+		oauthError(w, http.StatusBadRequest, "invalid_request", "The 'request_uri' parameter can't be pushed.")
+		return
+	case !o.validRedirect(params.Get("redirect_uri")):
+		oauthError(w, http.StatusBadRequest, "invalid_request", "The 'redirect_uri' parameter must be a Login redirect URI in the client app settings.")
+		return
+	}
+
+	pushed := url.Values{}
+	for name, values := range params {
+		if name != "client_secret" {
+			pushed[name] = values
+		}
+	}
+	pushed.Set("client_id", clientID)
+
+	requestURI := "urn:ietf:params:oauth:request_uri:" + randomString(24)
+	o.mu.Lock()
+	o.pushed[requestURI] = &pushedRequest{params: pushed, expires: time.Now().Add(time.Minute)}
+	o.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"request_uri": requestURI, "expires_in": 60})
+}
+
+// Resolves the user logging in, either from the session token or the submitted login form.
+func (o *Okta) login(r *http.Request, params url.Values) (string, bool) {
+
+	if sessionToken := params.Get("sessionToken"); len(sessionToken) > 0 {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		username, ok := o.sessions[sessionToken]
+		// Session tokens are single use.
+		delete(o.sessions, sessionToken)
+		return username, ok
+	}
+
+	if r.Method == http.MethodPost {
+		username := r.PostForm.Get("username")
+		user, ok := o.config.Users[username]
+		if ok && len(user.Status) == 0 && subtle.ConstantTimeCompare([]byte(user.Password), []byte(r.PostForm.Get("password"))) == 1 {
+			return username, true
+		}
+	}
+	return "", false
+}
+
+// POST /v1/token
+func (o *Okta) token(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		oauthError(w, http.StatusMethodNotAllowed, "invalid_request", "The endpoint only supports POST.")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "The request body was not well-formed.")
+		return
+	}
+	clientID, ok := o.authenticateClient(r)
+	if !ok {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed.")
+		return
+	}
+	jkt, ok := o.tokenDPoP(w, r)
+	if !ok {
+		return
+	}
+	params := r.PostForm
+
+	switch params.Get("grant_type") {
+
+	case "authorization_code":
+		o.mu.Lock()
+		code, ok := o.codes[params.Get("code")]
+		// Codes are single use, even when the exchange fails.
+		delete(o.codes, params.Get("code"))
+		o.mu.Unlock()
+
+		switch {
+		case !ok || time.Now().After(code.expires) || code.clientID != clientID:
+			oauthError(w, http.StatusBadRequest, "invalid_grant", "The authorization code is invalid or has expired.")
+		case code.redirectURI != params.Get("redirect_uri"):
+			oauthError(w, http.StatusBadRequest, "invalid_grant", "The 'redirect_uri' does not match the redirection URI used in the authorization request.")
+		case !verifyChallenge(code, params.Get("code_verifier")):
+			oauthError(w, http.StatusBadRequest, "invalid_grant", "PKCE verification failed.")
+		default:
+			o.issueTokens(w, r, issuance{username: code.username, clientID: clientID, scope: code.scope, nonce: code.nonce, jkt: jkt})
+		}
+
+	case "refresh_token":
+		o.mu.Lock()
+		refresh, ok := o.tokens[params.Get("refresh_token")]
+		// Refresh tokens issued to DPoP clients are bound to the same key.
+		valid := ok && refresh.refresh && !refresh.revoked && refresh.clientID == clientID && refresh.jkt == jkt
+		if valid {
+			// Refresh tokens are rotated on use.
+			refresh.revoked = true
+		}
+		o.mu.Unlock()
+
+		if !valid {
+			oauthError(w, http.StatusBadRequest, "invalid_grant", "The refresh token is invalid or expired.")
+			return
+		}
+		scope := refresh.scope
+		if requested := params.Get("scope"); len(requested) > 0 {
+			scope = requested
+		}
+		o.issueTokens(w, r, issuance{username: refresh.username, clientID: clientID, scope: scope, jkt: jkt})
+
+	case "password":
+		user, ok := o.config.Users[params.Get("username")]
+		switch {
+		case !ok || subtle.ConstantTimeCompare([]byte(user.Password), []byte(params.Get("password"))) != 1:
+			oauthError(w, http.StatusBadRequest, "invalid_grant", "The credentials provided were invalid.")
+		case len(user.Status) > 0:
+			oauthError(w, http.StatusBadRequest, "invalid_grant", fmt.Sprintf("The user is %v.", user.Status))
+		// Okta refuses the password grant when the sign-on policy asks for a second factor.
+		case len(user.Factors) > 0:
+			oauthError(w, http.StatusBadRequest, "access_denied", "Policy evaluation failed for this request, please check the policy configurations.")
+		default:
+			o.issueTokens(w, r, issuance{username: params.Get("username"), clientID: clientID, scope: params.Get("scope"), jkt: jkt})
+		}
+
+	case tokenExchangeGrantType:
+		subject, ok := o.activeToken(params.Get("subject_token"))
+		switch {
+		case params.Get("subject_token_type") != tokenTypeAccessToken:
+			oauthError(w, http.StatusBadRequest, "invalid_request", "The 'subject_token_type' must be an access token.")
+		case len(params.Get("audience")) == 0:
+			oauthError(w, http.StatusBadRequest, "invalid_request", "The 'audience' parameter is required.")
+		case !ok || subject.refresh:
+			oauthError(w, http.StatusBadRequest, "invalid_grant", "The subject token is invalid or has expired.")
+		default:
+			scope := params.Get("scope")
+			if len(scope) == 0 {
+				scope = subject.scope
+			}
+			o.issueTokens(w, r, issuance{
+				username:  subject.username,
+				clientID:  clientID,
+				scope:     scope,
+				audience:  params.Get("audience"),
+				exchanged: true,
+				jkt:       jkt,
+			})
+		}
+
+	default:
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", fmt.Sprintf("The grant type '%v' is not supported.", params.Get("grant_type")))
+	}
+}
+
+// Checks the client ID and, for confidential clients, the client secret sent with the
+// request either through basic authentication or in the form body.
+func (o *Okta) authenticateClient(r *http.Request) (string, bool) {
+
+	// tls_client_auth clients authenticate with the certificate verified during the handshake,
+	// which is only asked for by the mutual TLS endpoint aliases.
+	if o.config.TLSClientAuth {
+		clientID := r.PostForm.Get("client_id")
+		if len(o.config.ClientID) > 0 && clientID != o.config.ClientID {
+			return clientID, false
+		}
+		mtls := strings.Contains(r.URL.Path, "/mtls/")
+		return clientID, mtls && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(clientID) > 0
+	}
+
+	clientID, clientSecret, basic := r.BasicAuth()
+	if !basic {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	if len(o.config.ClientID) > 0 && clientID != o.config.ClientID {
+		return clientID, false
+	}
+	if len(o.config.ClientSecret) > 0 && subtle.ConstantTimeCompare([]byte(o.config.ClientSecret), []byte(clientSecret)) != 1 {
+		return clientID, false
+	}
+	return clientID, len(clientID) > 0
+}
+
+func verifyChallenge(code *authorization, verifier string) bool {
+	switch {
+	case len(code.challenge) == 0:
+		return len(verifier) == 0
+	case code.challengeType == "plain":
+		return verifier == code.challenge
+	default:
+		return len(verifier) > 0 && pkce.CodeChallenge(verifier) == code.challenge
+	}
+}
+
+// Issues an access token, plus an ID token for the openid scope and a refresh token for the
+// offline_access scope, and writes the token response.
+func (o *Okta) issueTokens(w http.ResponseWriter, r *http.Request, issue issuance) {
+	response, err := o.mintTokens(r, issue)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Signs and records the tokens of a grant, returning the /token response.
+func (o *Okta) mintTokens(r *http.Request, issue issuance) (map[string]interface{}, error) {
+
+	username, clientID, scope := issue.username, issue.clientID, issue.scope
+	now := time.Now()
+	expires := now.Add(o.config.TokenLifetime)
+	issuer := o.issuer(r)
+	scopes := strings.Fields(scope)
+	audience := issue.audience
+	if len(audience) == 0 {
+		audience = "api://" + o.config.AuthorizationServerID
+	}
+
+	accessClaims := map[string]interface{}{
+		"ver": 1,
+		"jti": "AT." + randomString(16),
+		"iss": issuer,
+		"aud": audience,
+		"iat": now.Unix(),
+		"exp": expires.Unix(),
+		"cid": clientID,
+		"uid": userID(username),
+		"scp": scopes,
+		"sub": username,
+	}
+	tokenType := "Bearer"
+	cnf := map[string]string{}
+	if len(issue.jkt) > 0 {
+		cnf["jkt"] = issue.jkt
+		tokenType = "DPoP"
+	}
+	// Clients authenticated with their certificate get tokens bound to it.
+	if o.config.TLSClientAuth && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 && !issue.frontChannel {
+		thumbprint := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+		cnf["x5t#S256"] = base64.RawURLEncoding.EncodeToString(thumbprint[:])
+	}
+	if len(cnf) > 0 {
+		accessClaims["cnf"] = cnf
+	}
+	accessToken, err := signJWT(o.key, o.kid, accessClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	response := map[string]interface{}{
+		"token_type":   tokenType,
+		"expires_in":   int(o.config.TokenLifetime.Seconds()),
+		"access_token": accessToken,
+		"scope":        scope,
+	}
+
+	if issue.exchanged {
+		response["issued_token_type"] = tokenTypeAccessToken
+	}
+
+	o.mu.Lock()
+	o.tokens[accessToken] = &grant{username: username, clientID: clientID, scope: scope, jkt: issue.jkt, issued: now, expires: expires}
+	if hasScope(scopes, "offline_access") && !issue.exchanged && !issue.frontChannel {
+		refreshToken := randomString(32)
+		o.tokens[refreshToken] = &grant{username: username, clientID: clientID, scope: scope, jkt: issue.jkt, refresh: true, issued: now}
+		response["refresh_token"] = refreshToken
+	}
+	o.mu.Unlock()
+
+	if hasScope(scopes, "openid") && !issue.exchanged {
+		claims := o.userClaims(username)
+		claims["ver"] = 1
+		claims["iss"] = issuer
+		claims["aud"] = clientID
+		claims["iat"] = now.Unix()
+		claims["exp"] = expires.Unix()
+		claims["auth_time"] = now.Unix()
+		claims["amr"] = []string{"pwd"}
+		claims["jti"] = "ID." + randomString(16)
+		if len(issue.nonce) > 0 {
+			claims["nonce"] = issue.nonce
+		}
+		idToken, err := signJWT(o.key, o.kid, claims)
+		if err != nil {
+			return nil, err
+		}
+		response["id_token"] = idToken
+	}
+	return response, nil
+}
+
+// GET /.well-known/openid-configuration
+func (o *Okta) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := o.issuer(r)
+	var mtlsAliases map[string]string
+	if r.TLS != nil {
+		mtlsAliases = map[string]string{
+			"token_endpoint":                        issuer + "/mtls/v1/token",
+			"pushed_authorization_request_endpoint": issuer + "/mtls/v1/par",
+			"introspection_endpoint":                issuer + "/mtls/v1/introspect",
+			"revocation_endpoint":                   issuer + "/mtls/v1/revoke",
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/v1/authorize",
+		"token_endpoint":                        issuer + "/v1/token",
+		"userinfo_endpoint":                     issuer + "/v1/userinfo",
+		"jwks_uri":                              issuer + "/v1/keys",
+		"introspection_endpoint":                issuer + "/v1/introspect",
+		"revocation_endpoint":                   issuer + "/v1/revoke",
+		"pushed_authorization_request_endpoint": issuer + "/v1/par",
+		"require_pushed_authorization_requests": o.config.RequirePAR,
+		"response_types_supported":              []string{"code", "token", "id_token", "token id_token", "code id_token", "code token", "code token id_token"},
+		"response_modes_supported":              []string{"query", "fragment", "form_post", "okta_post_message"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "password", tokenExchangeGrantType},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "profile", "email", "offline_access"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256", "plain"},
+		"dpop_signing_alg_values_supported":     []string{"ES256"},
+		"mtls_endpoint_aliases":                 mtlsAliases,
+	})
+}
+
+// GET /v1/userinfo
+func (o *Okta) userinfo(w http.ResponseWriter, r *http.Request) {
+	accessToken := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	token, ok := o.activeToken(accessToken)
+	if !ok || token.refresh {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="The access token is invalid."`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, o.userClaims(token.username))
+}
+
+// POST /v1/introspect
+func (o *Okta) introspect(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if _, ok := o.authenticateClient(r); !ok {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed.")
+		return
+	}
+	token, ok := o.activeToken(r.PostForm.Get("token"))
+	if !ok {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"active": false})
+		return
+	}
+	response := map[string]interface{}{
+		"active":     true,
+		"scope":      token.scope,
+		"username":   token.username,
+		"sub":        token.username,
+		"uid":        userID(token.username),
+		"client_id":  token.clientID,
+		"iat":        token.issued.Unix(),
+		"iss":        o.issuer(r),
+		"token_type": "Bearer",
+	}
+	if !token.expires.IsZero() {
+		response["exp"] = token.expires.Unix()
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// POST /v1/revoke
+func (o *Okta) revoke(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if _, ok := o.authenticateClient(r); !ok {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed.")
+		return
+	}
+	o.mu.Lock()
+	if token, ok := o.tokens[r.PostForm.Get("token")]; ok {
+		token.revoked = true
+	}
+	o.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (o *Okta) activeToken(value string) (*grant, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	token, ok := o.tokens[value]
+	if !ok || token.revoked || (!token.expires.IsZero() && time.Now().After(token.expires)) {
+		return nil, false
+	}
+	return token, true
+}
+
+func (o *Okta) userClaims(username string) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub":                userID(username),
+		"name":               username,
+		"preferred_username": username,
+		"email":              username,
+	}
+	for name, value := range o.config.Users[username].Claims {
+		claims[name] = value
+	}
+	return claims
+}
+
+func (o *Okta) validRedirect(redirectURI string) bool {
+	if len(redirectURI) == 0 {
+		return false
+	}
+	if len(o.config.RedirectURIs) == 0 {
+		return true
+	}
+	for _, registered := range o.config.RedirectURIs {
+		if registered == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// Redirects the browser back to the application with the given parameters in the query.
+// Returns the authorization response to the REDIRECT URI using the response mode.
+func respond(w http.ResponseWriter, r *http.Request, redirectURI string, responseMode string, params url.Values) {
+	switch responseMode {
+	case "fragment":
+		fragment := url.Values{}
+		for name, values := range params {
+			if len(values) > 0 && len(values[0]) > 0 {
+				fragment[name] = values
+			}
+		}
+		http.Redirect(w, r, redirectURI+"#"+fragment.Encode(), http.StatusFound)
+	case "form_post":
+		formPost(w, redirectURI, params)
+	case "okta_post_message":
+		postMessage(w, redirectURI, params)
+	default:
+		redirect(w, r, redirectURI, params)
+	}
+}
+
+func redirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		oktaError(w, http.StatusBadRequest, "invalid_request", "The 'redirect_uri' is not a valid URI.")
+		return
+	}
+	query := target.Query()
+	for name, values := range params {
+		if len(values) > 0 && len(values[0]) > 0 {
+			query[name] = values
+		}
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// Renders a minimal login form that posts back to /authorize with the original parameters.
+// Writes the auto-submitting form Okta returns for response_mode=form_post.
+func formPost(w http.ResponseWriter, redirectURI string, params url.Values) {
+	var inputs strings.Builder
+	for name, values := range params {
+		if len(values) == 0 || len(values[0]) == 0 {
+			continue
+		}
+		fmt.Fprintf(&inputs, `<input type="hidden" name="%s" value="%s"/>`+"\n", html.EscapeString(name), html.EscapeString(values[0]))
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head><title>oktatest - Redirecting</title></head>
+<body onload="document.forms[0].submit()">
+<noscript><p>Click Continue to finish signing in.</p></noscript>
+<form method="post" action="%s">
+%s<noscript><button type="submit">Continue</button></noscript>
+</form>
+</body>
+</html>
+`, html.EscapeString(redirectURI), inputs.String())
+}
+
+// Writes the page Okta returns for response_mode=okta_post_message, which hands the response
+// to the parent window. Like Okta, the values are escaped as JavaScript \xHH sequences.
+func postMessage(w http.ResponseWriter, redirectURI string, params url.Values) {
+	var data []string
+	for name, values := range params {
+		if len(values) == 0 || len(values[0]) == 0 {
+			continue
+		}
+		data = append(data, fmt.Sprintf("        '%s': '%s'", escapeJS(name), escapeJS(values[0])))
+	}
+	origin := redirectURI
+	if target, err := url.Parse(redirectURI); err == nil {
+		origin = target.Scheme + "://" + target.Host
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head><title>oktatest - Redirecting</title></head>
+<body>
+<script type="text/javascript">
+    (function(window, document, undefined) {
+      var redirectUri = '%s';
+      var data = {
+%s
+      };
+      window.parent.postMessage(data, redirectUri);
+    })(window, document);
+</script>
+</body>
+</html>
+`, escapeJS(origin), strings.Join(data, ",\n"))
+}
+
+func escapeJS(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '.', r == ' ':
+			b.WriteRune(r)
+		case r < 0x100:
+			fmt.Fprintf(&b, `\x%02X`, r)
+		case r < 0x10000:
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			high, low := utf16.EncodeRune(r)
+			fmt.Fprintf(&b, `\u%04X\u%04X`, high, low)
+		}
+	}
+	return b.String()
+}
+
+func loginPage(w http.ResponseWriter, params url.Values, failed bool) {
+	var hidden strings.Builder
+	for name, values := range params {
+		if name == "username" || name == "password" {
+			continue
+		}
+		for _, value := range values {
+			fmt.Fprintf(&hidden, `<input type="hidden" name="%s" value="%s">`+"\n", html.EscapeString(name), html.EscapeString(value))
+		}
+	}
+	message := ""
+	if failed {
+		message = "<p>Authentication failed</p>"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head><title>oktatest - Sign In</title></head>
+<body>
+<h3>Sign In</h3>
+%s<form method="POST">
+%s<input name="username" placeholder="Username" autofocus>
+<input name="password" type="password" placeholder="Password">
+<button type="submit">Sign In</button>
+</form>
+</body>
+</html>
+`, message, hidden.String())
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Derives a stable Okta style user ID from the username.
+func userID(username string) string {
+	sum := sha256.Sum256([]byte(username))
+	return fmt.Sprintf("00u%x", sum[:8])
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func oktaError(w http.ResponseWriter, status int, code string, summary string) {
+	writeJSON(w, status, map[string]interface{}{
+		"errorCode":    code,
+		"errorSummary": summary,
+		"errorLink":    code,
+		"errorId":      "oae" + randomString(8),
+		"errorCauses":  []interface{}{},
+	})
+}
+
+func oauthError(w http.ResponseWriter, status int, code string, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
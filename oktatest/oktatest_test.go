@@ -0,0 +1,274 @@
+package oktatest_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/js10x/okta-token-vendor/oktatest"
+	"github.com/js10x/okta-token-vendor/pkce"
+)
+
+const redirectURI = "http://localhost:4200/login/callback"
+
+func newServer() *oktatest.Server {
+	return oktatest.NewServer(oktatest.Config{
+		ClientID:     "CLIENT_ID",
+		RedirectURIs: []string{redirectURI},
+		Users: map[string]oktatest.User{
+			"user":   {Password: "pw"},
+			"locked": {Password: "pw", Status: "LOCKED_OUT"},
+			"mfa":    {Password: "pw", Factors: []string{"push"}},
+		},
+	})
+}
+
+// Client that does not follow redirects, like the one used by the token vendor.
+var client = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func Test_Authn(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+
+	scenarios := []struct {
+		username       string
+		password       string
+		expectedStatus string
+		expectedCode   int
+	}{
+		{username: "user", password: "pw", expectedStatus: "SUCCESS", expectedCode: http.StatusOK},
+		{username: "user", password: "wrong", expectedCode: http.StatusUnauthorized},
+		{username: "nobody", password: "pw", expectedCode: http.StatusUnauthorized},
+		{username: "locked", password: "pw", expectedStatus: "LOCKED_OUT", expectedCode: http.StatusOK},
+		{username: "mfa", password: "pw", expectedStatus: "MFA_REQUIRED", expectedCode: http.StatusOK},
+	}
+
+	for _, test := range scenarios {
+		body := strings.NewReader(`{"username":"` + test.username + `","password":"` + test.password + `"}`)
+		response, err := client.Post(server.URL+"/api/v1/authn", "application/json", body)
+		if err != nil {
+			t.Fatalf("Unexpected error [%v]", err)
+		}
+		var result struct {
+			Status       string `json:"status"`
+			SessionToken string `json:"sessionToken"`
+		}
+		json.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+
+		if response.StatusCode != test.expectedCode || result.Status != test.expectedStatus {
+			t.Errorf("[%v] Did not get the expected result. Expected ['%v' '%v'] Result ['%v' '%v']",
+				test.username, test.expectedCode, test.expectedStatus, response.StatusCode, result.Status)
+		}
+		if (result.Status == "SUCCESS") != (len(result.SessionToken) > 0) {
+			t.Errorf("[%v] Session token should only be issued on SUCCESS", test.username)
+		}
+	}
+}
+
+func Test_AuthorizationCode_With_PKCE(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+
+	scenarios := []struct {
+		verifier      func(verifier string) string
+		expectedError string
+	}{
+		{verifier: func(verifier string) string { return verifier }},
+		{verifier: func(verifier string) string { return verifier + "x" }, expectedError: "invalid_grant"},
+		{verifier: func(verifier string) string { return "" }, expectedError: "invalid_grant"},
+	}
+
+	for _, test := range scenarios {
+
+		sessionToken := login(t, server, "user", "pw")
+		verifier, query, err := pkce.AuthCodeQuery("CLIENT_ID", redirectURI, sessionToken)
+		if err != nil {
+			t.Fatalf("Unexpected error [%v]", err)
+		}
+		response, err := client.Get(server.Issuer() + "/v1/authorize" + query)
+		if err != nil {
+			t.Fatalf("Unexpected error [%v]", err)
+		}
+		response.Body.Close()
+		location, _ := url.Parse(response.Header.Get("Location"))
+		if response.StatusCode != http.StatusFound || len(location.Query().Get("code")) == 0 {
+			t.Fatalf("Did not redirect back with a code. Status ['%v'] Location ['%v']", response.StatusCode, location)
+		}
+
+		tokens := exchange(t, server, url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {"CLIENT_ID"},
+			"redirect_uri":  {redirectURI},
+			"code":          {location.Query().Get("code")},
+			"code_verifier": {test.verifier(verifier)},
+		})
+
+		if tokens["error"] != test.expectedError {
+			t.Errorf("Did not get the expected error. Expected ['%v'] Result ['%v']", test.expectedError, tokens["error"])
+		}
+		if len(test.expectedError) == 0 && (tokens["access_token"] == nil || tokens["id_token"] == nil) {
+			t.Errorf("Did not get the expected tokens ['%v']", tokens)
+		}
+	}
+}
+
+func Test_Authorize_Rejects_Unregistered_Redirect(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+
+	_, query, err := pkce.AuthCodeQuery("CLIENT_ID", "http://evil.com/callback", login(t, server, "user", "pw"))
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	response, err := client.Get(server.Issuer() + "/v1/authorize" + query)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Failed to reject an unregistered redirect URI. Status ['%v']", response.StatusCode)
+	}
+}
+
+func Test_Token_Lifecycle(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+
+	verifier, query, err := pkce.AuthCodeQuery("CLIENT_ID", redirectURI, login(t, server, "user", "pw"))
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	response, err := client.Get(server.Issuer() + "/v1/authorize" + query)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	response.Body.Close()
+	location, _ := url.Parse(response.Header.Get("Location"))
+	tokens := exchange(t, server, url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"CLIENT_ID"},
+		"redirect_uri":  {redirectURI},
+		"code":          {location.Query().Get("code")},
+		"code_verifier": {verifier},
+	})
+	accessToken, _ := tokens["access_token"].(string)
+
+	// The token is active until it is revoked.
+	if active := introspect(t, server, accessToken); !active {
+		t.Errorf("Issued token is not active")
+	}
+	request, _ := http.NewRequest(http.MethodGet, server.Issuer()+"/v1/userinfo", nil)
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	response, err = client.Do(request)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Userinfo rejected an active token. Status ['%v']", response.StatusCode)
+	}
+
+	response, err = client.PostForm(server.Issuer()+"/v1/revoke", url.Values{"client_id": {"CLIENT_ID"}, "token": {accessToken}})
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	response.Body.Close()
+	if active := introspect(t, server, accessToken); active {
+		t.Errorf("Revoked token is still active")
+	}
+}
+
+func Test_Discovery_And_Keys(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+
+	response, err := client.Get(server.Issuer() + "/.well-known/openid-configuration")
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	var metadata map[string]interface{}
+	json.NewDecoder(response.Body).Decode(&metadata)
+	response.Body.Close()
+	if metadata["issuer"] != server.Issuer() || metadata["token_endpoint"] != server.Issuer()+"/v1/token" {
+		t.Errorf("Did not get the expected metadata ['%v']", metadata)
+	}
+
+	response, err = client.Get(metadata["jwks_uri"].(string))
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	var keys struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	json.NewDecoder(response.Body).Decode(&keys)
+	response.Body.Close()
+	if len(keys.Keys) != 1 || keys.Keys[0]["kty"] != "RSA" {
+		t.Errorf("Did not get the expected keys ['%v']", keys)
+	}
+}
+
+func Test_InjectError(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+
+	server.Okta.InjectError("/api/v1/authn", http.StatusTooManyRequests, `{"errorCode":"E0000047"}`)
+
+	for _, expected := range []int{http.StatusTooManyRequests, http.StatusOK} {
+		response, err := client.Post(server.URL+"/api/v1/authn", "application/json", strings.NewReader(`{"username":"user","password":"pw"}`))
+		if err != nil {
+			t.Fatalf("Unexpected error [%v]", err)
+		}
+		response.Body.Close()
+		if response.StatusCode != expected {
+			t.Errorf("Did not get the expected status. Expected ['%v'] Result ['%v']", expected, response.StatusCode)
+		}
+	}
+}
+
+func login(t *testing.T, server *oktatest.Server, username string, password string) string {
+	body := strings.NewReader(`{"username":"` + username + `","password":"` + password + `"}`)
+	response, err := client.Post(server.URL+"/api/v1/authn", "application/json", body)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	defer response.Body.Close()
+	var result struct {
+		SessionToken string `json:"sessionToken"`
+	}
+	json.NewDecoder(response.Body).Decode(&result)
+	return result.SessionToken
+}
+
+func exchange(t *testing.T, server *oktatest.Server, form url.Values) map[string]interface{} {
+	response, err := client.PostForm(server.Issuer()+"/v1/token", form)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	defer response.Body.Close()
+	var result map[string]interface{}
+	json.NewDecoder(response.Body).Decode(&result)
+	if result["error"] == nil {
+		result["error"] = ""
+	}
+	return result
+}
+
+func introspect(t *testing.T, server *oktatest.Server, token string) bool {
+	response, err := client.PostForm(server.Issuer()+"/v1/introspect", url.Values{"client_id": {"CLIENT_ID"}, "token": {token}})
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	defer response.Body.Close()
+	var result struct {
+		Active bool `json:"active"`
+	}
+	json.NewDecoder(response.Body).Decode(&result)
+	return result.Active
+}
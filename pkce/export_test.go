@@ -0,0 +1,10 @@
+package pkce
+
+import "io"
+
+// Replaces the source of randomness, returning a function restoring it.
+func SetRandom(r io.Reader) func() {
+	previous := random
+	random = r
+	return func() { random = previous }
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"net/url"
 	"strings"
 )
@@ -32,26 +33,177 @@ func OAuth2URL(issuer string, endpointUri string) string {
 	return result
 }
 
+// Response modes, controlling how the authorization response is returned to the REDIRECT URI.
+const (
+	ResponseModeQuery    = "query"
+	ResponseModeFragment = "fragment"
+	ResponseModeFormPost = "form_post"
+	// Okta specific, returns a page that hands the response to the parent window with postMessage.
+	ResponseModeOktaPostMessage = "okta_post_message"
+)
+
+// Methods deriving the code challenge from the code verifier, see RFC 7636 [Section 4.2].
+const (
+	ChallengeMethodS256 = "S256"
+	// Sends the verifier itself as the challenge. Only for testing servers that require it.
+	ChallengeMethodPlain = "plain"
+)
+
+// Lengths of the code verifier allowed by RFC 7636 [Section 4.1], and the length used when
+// none is configured.
+const (
+	MinVerifierLength     = 43
+	MaxVerifierLength     = 128
+	DefaultVerifierLength = 80
+)
+
+// Source of the randomness of the verifier, state and nonce. Replaced in tests.
+var random io.Reader = rand.Reader
+
+// Parameters of the request made to the /authorize endpoint.
+type AuthParams struct {
+	ClientID    string
+	RedirectURI string
+	// Omitted when empty, so that the user is prompted to log in.
+	SessionToken string
+	// Space separated, "code" when empty. The implicit ("token", "id_token") and hybrid
+	// ("code id_token", ...) response types are legacy and only supported for testing older apps.
+	ResponseType string
+	// Omitted when empty, so that the authorization server picks the default for the response type.
+	ResponseMode string
+	// Space separated, "openid" when empty.
+	Scope string
+	// ChallengeMethodS256 when empty.
+	ChallengeMethod string
+	// Length of the code verifier in characters, DefaultVerifierLength when zero.
+	VerifierLength int
+}
+
 // Builds and returns the URL query parameters needed to get the authorization code.
 // Also returns the generated code verifier used to compute the code challenge.
-func AuthCodeQuery(clientID string, redirectUri string, sessionToken string) (string, string) {
+// The session token is omitted when empty, so that the user is prompted to log in.
+func AuthCodeQuery(clientID string, redirectUri string, sessionToken string) (string, string, error) {
+	return AuthQuery(AuthParams{ClientID: clientID, RedirectURI: redirectUri, SessionToken: sessionToken})
+}
+
+// Builds and returns the URL query parameters of an authorization request. Also returns the
+// generated code verifier, which is empty when the response type does not include a code.
+func AuthQuery(p AuthParams) (string, string, error) {
+
+	responseType := p.ResponseType
+	if len(strings.TrimSpace(responseType)) == 0 {
+		responseType = "code"
+	}
+	scope := p.Scope
+	if len(strings.TrimSpace(scope)) == 0 {
+		scope = "openid"
+	}
+
+	params := url.Values{}
+	params.Add("client_id", p.ClientID)
 
 	// According to RFC7636 [Section 4] [https://datatracker.ietf.org/doc/html/rfc7636#section-4]
 	// The code verifier is a high-entropy cryptographic random URL-safe string with a recommended length of between 43 and 128 characters.
-	code_verifier := base64UrlEncodedString(60)
-	code_challenge := CodeChallenge(code_verifier)
+	var code_verifier string
+	if HasResponseType(responseType, "code") {
+		length := p.VerifierLength
+		if length == 0 {
+			length = DefaultVerifierLength
+		}
+		method := p.ChallengeMethod
+		if len(method) == 0 {
+			method = ChallengeMethodS256
+		}
+		verifier, err := NewVerifier(length)
+		if err != nil {
+			return "", "", err
+		}
+		challenge, err := Challenge(verifier, method)
+		if err != nil {
+			return "", "", err
+		}
+		code_verifier = verifier
+		params.Add("code_challenge_method", method)
+		params.Add("code_challenge", challenge)
+	}
 
-	params := url.Values{}
-	params.Add("client_id", clientID)
-	params.Add("code_challenge_method", "S256")
-	params.Add("code_challenge", code_challenge)
-	params.Add("redirect_uri", redirectUri)
-	params.Add("response_type", "code")
-	params.Add("scope", "openid")
-	params.Add("nonce", base64EncodedString(20))
-	params.Add("state", base64EncodedString(20))
-	params.Add("sessionToken", sessionToken)
-	return code_verifier, "?" + params.Encode()
+	// The state and nonce travel in URLs, so they only use URL-safe characters.
+	nonce, err := randomString(20)
+	if err != nil {
+		return "", "", err
+	}
+	state, err := randomString(20)
+	if err != nil {
+		return "", "", err
+	}
+
+	params.Add("redirect_uri", p.RedirectURI)
+	params.Add("response_type", responseType)
+	if len(p.ResponseMode) > 0 {
+		params.Add("response_mode", p.ResponseMode)
+	}
+	params.Add("scope", scope)
+	params.Add("nonce", nonce)
+	params.Add("state", state)
+	// Interactive logins authenticate in the browser instead of with a session token.
+	if len(p.SessionToken) > 0 {
+		params.Add("sessionToken", p.SessionToken)
+	}
+	return code_verifier, "?" + params.Encode(), nil
+}
+
+// Reports whether the space separated response type includes the given one, e.g. "token" in "code token".
+func HasResponseType(responseType string, want string) bool {
+	for _, t := range strings.Fields(responseType) {
+		if t == want {
+			return true
+		}
+	}
+	return false
+}
+
+// Generates a code verifier of the given length, which must be between MinVerifierLength and
+// MaxVerifierLength characters.
+func NewVerifier(length int) (string, error) {
+	if length < MinVerifierLength || length > MaxVerifierLength {
+		return "", fmt.Errorf("the code verifier must be between %v and %v characters long, not %v", MinVerifierLength, MaxVerifierLength, length)
+	}
+	// Each byte encodes to 4/3 characters, so this is the fewest bytes encoding to the length,
+	// e.g. the 32 octets of RFC 7636 [Appendix B] for 43 characters.
+	verifier, err := randomString(3*(length-1)/4 + 1)
+	if err != nil {
+		return "", err
+	}
+	return verifier[:length], nil
+}
+
+// Validates a code verifier against RFC 7636 [Section 4.1]: between 43 and 128 characters,
+// all unreserved (ALPHA / DIGIT / "-" / "." / "_" / "~").
+func ValidateVerifier(verifier string) error {
+	if len(verifier) < MinVerifierLength || len(verifier) > MaxVerifierLength {
+		return fmt.Errorf("the code verifier must be between %v and %v characters long, not %v", MinVerifierLength, MaxVerifierLength, len(verifier))
+	}
+	for _, c := range verifier {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune("-._~", c)) {
+			return fmt.Errorf("the code verifier must only hold unreserved characters, not [%c]", c)
+		}
+	}
+	return nil
+}
+
+// Derives the code challenge sent to /authorize from the code verifier, with the given
+// ChallengeMethodS256 or ChallengeMethodPlain method.
+func Challenge(verifier string, method string) (string, error) {
+	if err := ValidateVerifier(verifier); err != nil {
+		return "", err
+	}
+	switch method {
+	case ChallengeMethodS256:
+		return CodeChallenge(verifier), nil
+	case ChallengeMethodPlain:
+		return verifier, nil
+	}
+	return "", fmt.Errorf("unsupported code challenge method [%v], use %v or %v", method, ChallengeMethodS256, ChallengeMethodPlain)
 }
 
 // Computes a code challenge based on PKCE standards, which dicates that the code challenge
@@ -66,16 +218,12 @@ func CodeChallenge(verifier string) string {
 	return challenge
 }
 
-// Creates and returns a base64 string with standard encoding.
-func base64EncodedString(size int) string {
+// Creates and returns a base64 string with URL encoding and no padding, from the given number
+// of random bytes.
+func randomString(size int) (string, error) {
 	bytes := make([]byte, size)
-	rand.Read(bytes)
-	return base64.StdEncoding.EncodeToString(bytes)
-}
-
-// Creates and returns a base64 string with URL encoding.
-func base64UrlEncodedString(size int) string {
-	bytes := make([]byte, size)
-	rand.Read(bytes)
-	return base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(bytes)
+	if _, err := io.ReadFull(random, bytes); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
 }
@@ -1,6 +1,8 @@
 package pkce_test
 
 import (
+	"errors"
+	"net/url"
 	"strings"
 	"testing"
 
@@ -71,7 +73,10 @@ func Test_AuthCodeQuery(t *testing.T) {
 	}
 
 	for _, test := range scenarios {
-		verifier, params := pkce.AuthCodeQuery(test.clientID, test.redirectUri, test.sessionToken)
+		verifier, params, err := pkce.AuthCodeQuery(test.clientID, test.redirectUri, test.sessionToken)
+		if err != nil {
+			t.Fatalf("Unexpected error [%v]", err)
+		}
 
 		if len(verifier) <= 0 || len(params) <= 0 {
 			t.Errorf("Failed to build query parameters for the authorization code query")
@@ -79,6 +84,37 @@ func Test_AuthCodeQuery(t *testing.T) {
 	}
 }
 
+func Test_AuthQuery_Response_Types(t *testing.T) {
+	scenarios := []struct {
+		responseType     string
+		responseMode     string
+		expectedType     string
+		expectedVerifier bool
+	}{
+		{responseType: "", expectedType: "code", expectedVerifier: true},
+		{responseType: "token id_token", responseMode: pkce.ResponseModeFragment, expectedType: "token id_token"},
+		{responseType: "code id_token", responseMode: pkce.ResponseModeFormPost, expectedType: "code id_token", expectedVerifier: true},
+	}
+
+	for _, test := range scenarios {
+		verifier, query, err := pkce.AuthQuery(pkce.AuthParams{ClientID: "cid", RedirectURI: "callback", ResponseType: test.responseType, ResponseMode: test.responseMode})
+		if err != nil {
+			t.Fatalf("Unexpected error [%v]", err)
+		}
+		params, err := url.ParseQuery(strings.TrimPrefix(query, "?"))
+		if err != nil {
+			t.Fatalf("Unexpected error [%v]", err)
+		}
+
+		if params.Get("response_type") != test.expectedType || params.Get("response_mode") != test.responseMode {
+			t.Errorf("Did not get the expected result. Expected ['%v' '%v'] Result ['%v' '%v']", test.expectedType, test.responseMode, params.Get("response_type"), params.Get("response_mode"))
+		}
+		if (len(verifier) > 0) != test.expectedVerifier || (len(params.Get("code_challenge")) > 0) != test.expectedVerifier {
+			t.Errorf("[%v] Did not get the expected code challenge ['%v']", test.expectedType, params.Get("code_challenge"))
+		}
+	}
+}
+
 func Test_CodeChallenge_Returns_Valid_PKCE_String(t *testing.T) {
 
 	scenarios := []struct {
@@ -103,3 +139,179 @@ func Test_CodeChallenge_Returns_Valid_PKCE_String(t *testing.T) {
 		}
 	}
 }
+
+// Test vectors of RFC 7636 [Appendix B].
+const (
+	rfcVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	rfcChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func Test_Challenge_RFC7636_Appendix_B(t *testing.T) {
+
+	scenarios := []struct {
+		verifier      string
+		method        string
+		challenge     string
+		expectedError string
+	}{
+		{verifier: rfcVerifier, method: pkce.ChallengeMethodS256, challenge: rfcChallenge},
+		{verifier: rfcVerifier, method: pkce.ChallengeMethodPlain, challenge: rfcVerifier},
+		{verifier: rfcVerifier, method: "S512", expectedError: "unsupported code challenge method"},
+		{verifier: rfcVerifier[:42], method: pkce.ChallengeMethodS256, expectedError: "between 43 and 128"},
+		{verifier: strings.Repeat("a", 129), method: pkce.ChallengeMethodPlain, expectedError: "between 43 and 128"},
+		{verifier: rfcVerifier[:42] + "+", method: pkce.ChallengeMethodS256, expectedError: "unreserved"},
+	}
+
+	for _, test := range scenarios {
+		result, err := pkce.Challenge(test.verifier, test.method)
+		if len(test.expectedError) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+				t.Errorf("[%v] Did not get the expected error. Expected ['%v'] Result ['%v']", test.method, test.expectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%v] Unexpected error [%v]", test.method, err)
+		}
+		if result != test.challenge {
+			t.Errorf("[%v] Did not get the expected result. Expected ['%v'] Result ['%v']", test.method, test.challenge, result)
+		}
+	}
+
+	// The verifier of the RFC is derived from these octets.
+	octets := []byte{116, 24, 223, 180, 151, 153, 224, 37, 79, 250, 96, 125, 216, 173, 187, 186, 22, 212, 37, 77, 105, 214, 191, 240, 91, 88, 5, 88, 83, 132, 141, 121}
+	defer pkce.SetRandom(strings.NewReader(string(octets)))()
+	verifier, err := pkce.NewVerifier(pkce.MinVerifierLength)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	if verifier != rfcVerifier {
+		t.Errorf("Did not get the expected result. Expected ['%v'] Result ['%v']", rfcVerifier, verifier)
+	}
+}
+
+func Test_NewVerifier_Length(t *testing.T) {
+
+	scenarios := []struct {
+		length        int
+		expectedError bool
+	}{
+		{length: 42, expectedError: true},
+		{length: pkce.MinVerifierLength},
+		{length: 64},
+		{length: pkce.DefaultVerifierLength},
+		{length: pkce.MaxVerifierLength},
+		{length: 129, expectedError: true},
+	}
+
+	for _, test := range scenarios {
+		verifier, err := pkce.NewVerifier(test.length)
+		if test.expectedError {
+			if err == nil {
+				t.Errorf("[%v] Did not get the expected error. Result ['%v']", test.length, verifier)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%v] Unexpected error [%v]", test.length, err)
+		}
+		if len(verifier) != test.length {
+			t.Errorf("Did not get the expected result. Expected ['%v'] Result ['%v']", test.length, len(verifier))
+		}
+		if err := pkce.ValidateVerifier(verifier); err != nil {
+			t.Errorf("[%v] Unexpected error [%v]", test.length, err)
+		}
+	}
+}
+
+func Test_AuthQuery_Challenge_Method(t *testing.T) {
+
+	scenarios := []struct {
+		method         string
+		length         int
+		expectedMethod string
+		expectedLength int
+		expectedError  bool
+	}{
+		{expectedMethod: pkce.ChallengeMethodS256, expectedLength: pkce.DefaultVerifierLength},
+		{method: pkce.ChallengeMethodPlain, length: 43, expectedMethod: pkce.ChallengeMethodPlain, expectedLength: 43},
+		{method: pkce.ChallengeMethodS256, length: 128, expectedMethod: pkce.ChallengeMethodS256, expectedLength: 128},
+		{method: "S512", expectedError: true},
+		{length: 200, expectedError: true},
+	}
+
+	for _, test := range scenarios {
+		verifier, query, err := pkce.AuthQuery(pkce.AuthParams{ClientID: "cid", RedirectURI: "callback", ChallengeMethod: test.method, VerifierLength: test.length})
+		if test.expectedError {
+			if err == nil {
+				t.Errorf("[%v %v] Did not get the expected error. Result ['%v']", test.method, test.length, query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%v %v] Unexpected error [%v]", test.method, test.length, err)
+		}
+		params, err := url.ParseQuery(strings.TrimPrefix(query, "?"))
+		if err != nil {
+			t.Fatalf("Unexpected error [%v]", err)
+		}
+
+		if params.Get("code_challenge_method") != test.expectedMethod || len(verifier) != test.expectedLength {
+			t.Errorf("Did not get the expected result. Expected ['%v' '%v'] Result ['%v' '%v']", test.expectedMethod, test.expectedLength, params.Get("code_challenge_method"), len(verifier))
+		}
+		expected, _ := pkce.Challenge(verifier, test.expectedMethod)
+		if params.Get("code_challenge") != expected {
+			t.Errorf("Did not get the expected result. Expected ['%v'] Result ['%v']", expected, params.Get("code_challenge"))
+		}
+	}
+}
+
+func Test_AuthQuery_URL_Safe_State_And_Nonce(t *testing.T) {
+
+	for i := 0; i < 50; i++ {
+		_, query, err := pkce.AuthQuery(pkce.AuthParams{ClientID: "cid", RedirectURI: "callback"})
+		if err != nil {
+			t.Fatalf("Unexpected error [%v]", err)
+		}
+		params, err := url.ParseQuery(strings.TrimPrefix(query, "?"))
+		if err != nil {
+			t.Fatalf("Unexpected error [%v]", err)
+		}
+		for _, name := range []string{"state", "nonce"} {
+			if value := params.Get(name); len(value) == 0 || strings.ContainsAny(value, "+/=") || url.QueryEscape(value) != value {
+				t.Errorf("Did not get a URL-safe %v. Result ['%v']", name, value)
+			}
+		}
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("no entropy")
+}
+
+func Test_AuthQuery_Entropy_Failure(t *testing.T) {
+
+	defer pkce.SetRandom(failingReader{})()
+
+	scenarios := []struct {
+		responseType string
+	}{
+		{responseType: "code"},
+		{responseType: "token"},
+	}
+
+	for _, test := range scenarios {
+		verifier, query, err := pkce.AuthQuery(pkce.AuthParams{ClientID: "cid", RedirectURI: "callback", ResponseType: test.responseType})
+		if err == nil || !strings.Contains(err.Error(), "no entropy") {
+			t.Errorf("[%v] Did not get the expected error. Expected ['%v'] Result ['%v']", test.responseType, "no entropy", err)
+		}
+		if len(verifier) > 0 || len(query) > 0 {
+			t.Errorf("[%v] Did not expect a query when failing. Result ['%v' '%v']", test.responseType, verifier, query)
+		}
+	}
+	if _, err := pkce.NewVerifier(pkce.DefaultVerifierLength); err == nil {
+		t.Errorf("Did not get the expected error. Expected ['%v'] Result ['%v']", "no entropy", err)
+	}
+}
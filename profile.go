@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/js10x/okta-token-vendor/vendor"
+)
+
+// Name of the profile applied when -profile is not given, if the config file has one.
+const defaultProfile = "default"
+
+// Config file holding named profiles of flag values, so that the settings of each Okta app and
+// network (issuer, client ID, CA bundle, proxy, ...) don't need to be repeated on every run.
+//
+//	{
+//	  "profiles": {
+//	    "default": {"iss": "https://okta-domain.com/oauth2/default", "cid": "0x0", "ca-bundle": "corp-root.pem"},
+//	    "mock": {"iss": "https://127.0.0.1:8443/oauth2/default", "insecure-skip-verify": true}
+//	  }
+//	}
+//
+// Profile keys are flag names. Flags given on the command line take precedence.
+type configFile struct {
+	Profiles map[string]map[string]interface{} `json:"profiles"`
+}
+
+// Returns where the config file is kept by default, e.g. ~/.config/oktv/config.json.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "oktv", "config.json")
+}
+
+// Reads the config file. A missing file is the same as an empty one.
+func readConfig(path string) (*configFile, error) {
+	var config configFile
+	if len(path) == 0 {
+		return &config, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse the config file [%v]: %v", path, err)
+	}
+	return &config, nil
+}
+
+// Sets the flags that were not given on the command line from the profile.
+func applyProfile(fs *flag.FlagSet, config *configFile, name string) error {
+
+	profile, ok := config.Profiles[name]
+	if !ok {
+		if name == defaultProfile {
+			return nil
+		}
+		return fmt.Errorf("no profile named [%v] in the config file", name)
+	}
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	// Apply the keys in order, so that errors are reported deterministically.
+	keys := make([]string, 0, len(profile))
+	for key := range profile {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if given[key] || key == "profile" || key == "config" {
+			continue
+		}
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("unknown flag [%v] in the [%v] profile", key, name)
+		}
+		if err := fs.Set(key, fmt.Sprint(profile[key])); err != nil {
+			return fmt.Errorf("invalid value for [%v] in the [%v] profile: %v", key, name, err)
+		}
+	}
+	return nil
+}
+
+// Parses the flags, then fills in the ones not given from the selected profile.
+func (f *vendFlags) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return f.applyProfile(fs)
+}
+
+// Fills in the flags not given on the command line from the selected profile.
+func (f *vendFlags) applyProfile(fs *flag.FlagSet) error {
+	config, err := readConfig(f.configPath)
+	if err != nil {
+		return err
+	}
+	return applyProfile(fs, config, strings.TrimSpace(f.profile))
+}
+
+// Shows and edits the profiles of the config file.
+func setupConfig(fs *flag.FlagSet) func(args []string) {
+
+	var path string
+	fs.StringVar(&path, "config", defaultConfigPath(), "The config file holding the profiles.")
+	return func(args []string) {
+		fs.Parse(args)
+		config, err := readConfig(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		action, profile := fs.Arg(0), fs.Arg(1)
+		switch {
+		case action == "path":
+			fmt.Println(path)
+
+		case action == "list":
+			var names []string
+			for name := range config.Profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Println(name)
+			}
+
+		case action == "show" && fs.NArg() == 2:
+			values, ok := config.Profiles[profile]
+			if !ok {
+				fmt.Fprintf(os.Stderr, "no profile named [%v] in the config file\n", profile)
+				os.Exit(1)
+			}
+			content, _ := json.MarshalIndent(values, "", "  ")
+			fmt.Println(string(content))
+
+		case (action == "set" && fs.NArg() == 4) || (action == "unset" && fs.NArg() == 3):
+			name := fs.Arg(2)
+			if !isVendFlag(name) {
+				fmt.Fprintf(os.Stderr, "unknown flag [%v], run oktv help get to list the flags\n", name)
+				os.Exit(1)
+			}
+			if config.Profiles == nil {
+				config.Profiles = map[string]map[string]interface{}{}
+			}
+			if config.Profiles[profile] == nil {
+				config.Profiles[profile] = map[string]interface{}{}
+			}
+			if action == "set" {
+				config.Profiles[profile][name] = fs.Arg(3)
+			} else {
+				delete(config.Profiles[profile], name)
+			}
+			if err := writeConfig(path, config); err != nil {
+				fmt.Fprintf(os.Stderr, "Error occurred when writing the config file: %v\n", err)
+				os.Exit(1)
+			}
+
+		default:
+			fmt.Fprintf(os.Stderr, "Usage: oktv config path | list | show <profile> | set <profile> <flag> <value> | unset <profile> <flag>\n")
+			os.Exit(2)
+		}
+	}
+}
+
+// Reports whether the name is a flag taken by the commands vending tokens.
+func isVendFlag(name string) bool {
+	var f vendFlags
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	f.register(fs)
+	return fs.Lookup(name) != nil && name != "profile" && name != "config"
+}
+
+// Writes the config file, only readable by the current user since profiles can hold secrets.
+func writeConfig(path string, config *configFile) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return vendor.WriteFileAtomic(path, append(content, '\n'))
+}
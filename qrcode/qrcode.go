@@ -0,0 +1,487 @@
+// Package qrcode encodes short strings, such as verification URLs, as QR codes that can be
+// printed to a terminal. Only byte mode with the low error correction level is supported,
+// for versions 1 through 10 (up to 271 bytes), which is plenty for a URL.
+package qrcode
+
+import (
+	"fmt"
+	"strings"
+)
+
+const maxVersion = 10
+
+// Error correction codewords per block and number of blocks for each version, at level L.
+var eccCodewordsPerBlock = [maxVersion + 1]int{0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18}
+var numErrorCorrectionBlocks = [maxVersion + 1]int{0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4}
+
+// Code is an encoded QR code symbol.
+type Code struct {
+	Size       int
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// Encodes the text as a QR code, choosing the smallest version that fits it.
+func Encode(text string) (*Code, error) {
+
+	data := []byte(text)
+	version := 0
+	for v := 1; v <= maxVersion; v++ {
+		if dataCapacityBits(v) >= 4+countBits(v)+8*len(data) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("text is too long to encode as a QR code [%v bytes]", len(data))
+	}
+
+	// Mode indicator (byte mode), character count, then the data itself.
+	var bits bitBuffer
+	bits.append(0x4, 4)
+	bits.append(len(data), countBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	// Terminator, padding to a byte boundary, then alternating pad bytes up to capacity.
+	capacity := dataCapacityBits(version)
+	bits.append(0, minInt(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i>>3] |= 1 << uint(7-(i&7))
+		}
+	}
+
+	code := newCode(version)
+	code.drawFunctionPatterns(version)
+	code.drawCodewords(addErrorCorrection(version, codewords))
+
+	// Pick the mask with the lowest penalty, as the specification requires.
+	bestMask, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		code.applyMask(mask)
+		code.drawFormatBits(mask)
+		if penalty := code.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			bestMask, bestPenalty = mask, penalty
+		}
+		code.applyMask(mask) // Masks are their own inverse.
+	}
+	code.applyMask(bestMask)
+	code.drawFormatBits(bestMask)
+	return code, nil
+}
+
+// Reports whether the module at column x, row y is dark.
+func (c *Code) Dark(x int, y int) bool {
+	return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.modules[y][x]
+}
+
+// Renders the code with Unicode half blocks, two rows of modules per line of text, surrounded
+// by a quiet zone. Light modules are drawn as blocks, so the code scans on dark terminals.
+func (c *Code) Terminal() string {
+	const quiet = 2
+	var sb strings.Builder
+	for y := -quiet; y < c.Size+quiet; y += 2 {
+		for x := -quiet; x < c.Size+quiet; x++ {
+			top, bottom := !c.Dark(x, y), !c.Dark(x, y+1)
+			if y+1 >= c.Size+quiet {
+				bottom = false
+			}
+			switch {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func newCode(version int) *Code {
+	size := version*4 + 17
+	code := &Code{Size: size, modules: make([][]bool, size), isFunction: make([][]bool, size)}
+	for i := 0; i < size; i++ {
+		code.modules[i] = make([]bool, size)
+		code.isFunction[i] = make([]bool, size)
+	}
+	return code
+}
+
+func (c *Code) setFunction(x int, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.isFunction[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns(version int) {
+
+	// Timing patterns
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns, including their separators
+	for _, corner := range [][2]int{{3, 3}, {c.Size - 4, 3}, {3, c.Size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := corner[0]+dx, corner[1]+dy
+				if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+					continue
+				}
+				distance := maxInt(absInt(dx), absInt(dy))
+				c.setFunction(x, y, distance != 2 && distance != 4)
+			}
+		}
+	}
+
+	// Alignment patterns, skipping the ones that would overlap the finder patterns
+	positions := alignmentPositions(version)
+	for i, x := range positions {
+		for j, y := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == len(positions)-1) || (i == len(positions)-1 && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.setFunction(x+dx, y+dy, maxInt(absInt(dx), absInt(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format information areas, the real bits are drawn once the mask is chosen.
+	c.drawFormatBits(0)
+
+	// Version information
+	if version >= 7 {
+		remainder := version
+		for i := 0; i < 12; i++ {
+			remainder = (remainder << 1) ^ ((remainder >> 11) * 0x1F25)
+		}
+		bits := version<<12 | remainder
+		for i := 0; i < 18; i++ {
+			dark := (bits>>uint(i))&1 != 0
+			a, b := c.Size-11+i%3, i/3
+			c.setFunction(a, b, dark)
+			c.setFunction(b, a, dark)
+		}
+	}
+}
+
+// Draws both copies of the format information for error correction level L and the given mask.
+func (c *Code) drawFormatBits(mask int) {
+
+	const levelL = 1
+	data := levelL<<3 | mask
+	remainder := data
+	for i := 0; i < 10; i++ {
+		remainder = (remainder << 1) ^ ((remainder >> 9) * 0x537)
+	}
+	bits := (data<<10 | remainder) ^ 0x5412
+	bit := func(i int) bool { return (bits>>uint(i))&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(i))
+	}
+	c.setFunction(8, c.Size-8, true) // Always dark
+}
+
+// Places the codewords in the zigzag pattern, skipping function modules.
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !c.isFunction[y][x] && i < len(data)*8 {
+					c.modules[y][x] = (data[i>>3]>>uint(7-(i&7)))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.isFunction[y][x] {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// Scores how hard the symbol is to scan, using the four penalty rules of the specification.
+func (c *Code) penalty() int {
+
+	penalty := 0
+	line := make([]bool, c.Size)
+	for _, horizontal := range []bool{true, false} {
+		for i := 0; i < c.Size; i++ {
+			for j := 0; j < c.Size; j++ {
+				if horizontal {
+					line[j] = c.modules[i][j]
+				} else {
+					line[j] = c.modules[j][i]
+				}
+			}
+
+			// Runs of five or more modules of the same color
+			run := 1
+			for j := 1; j <= c.Size; j++ {
+				if j < c.Size && line[j] == line[j-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += 3 + run - 5
+				}
+				run = 1
+			}
+
+			// Patterns that look like finder patterns
+			for j := 0; j+11 <= c.Size; j++ {
+				if matchesFinderLike(line[j:j+11], false) || matchesFinderLike(line[j:j+11], true) {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	// 2x2 blocks of the same color
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x+1 < c.Size && y+1 < c.Size {
+				color := c.modules[y][x]
+				if color == c.modules[y][x+1] && color == c.modules[y+1][x] && color == c.modules[y+1][x+1] {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	// Balance of dark and light modules
+	total := c.Size * c.Size
+	k := (absInt(dark*20-total*10)+total-1)/total - 1
+	return penalty + maxInt(k, 0)*10
+}
+
+// Matches 1011101 preceded (or followed, when reversed) by four light modules.
+func matchesFinderLike(window []bool, reversed bool) bool {
+	pattern := []bool{false, false, false, false, true, false, true, true, true, false, true}
+	for i := range pattern {
+		expected := pattern[i]
+		if reversed {
+			expected = pattern[len(pattern)-1-i]
+		}
+		if window[i] != expected {
+			return false
+		}
+	}
+	return true
+}
+
+// Splits the data into blocks, computes the Reed-Solomon error correction codewords for
+// each block, and interleaves the result.
+func addErrorCorrection(version int, data []byte) []byte {
+
+	numBlocks := numErrorCorrectionBlocks[version]
+	blockEccLen := eccCodewordsPerBlock[version]
+	rawCodewords := rawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := reedSolomonDivisor(blockEccLen)
+	blocks := make([][]byte, 0, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		length := shortBlockLen - blockEccLen
+		if i >= numShortBlocks {
+			length++
+		}
+		block := append([]byte{}, data[k:k+length]...)
+		k += length
+		block = append(block, reedSolomonRemainder(block, divisor)...)
+		blocks = append(blocks, block)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := 0; i <= shortBlockLen; i++ {
+		for j, block := range blocks {
+			// Short blocks have one less data codeword, skip the gap when interleaving.
+			if i == shortBlockLen-blockEccLen && j < numShortBlocks {
+				continue
+			}
+			index := i
+			if j < numShortBlocks && i > shortBlockLen-blockEccLen {
+				index--
+			}
+			if index < len(block) {
+				result = append(result, block[index])
+			}
+		}
+	}
+	return result
+}
+
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func reedSolomonRemainder(data []byte, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// Multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x byte, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	positions := make([]int, numAlign)
+	positions[0] = 6
+	for i, pos := numAlign-1, version*4+10; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// Number of modules available for data and error correction codewords.
+func rawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+func dataCapacityBits(version int) int {
+	return (rawDataModules(version)/8 - eccCodewordsPerBlock[version]*numErrorCorrectionBlocks[version]) * 8
+}
+
+func countBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value int, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>uint(i))&1 != 0)
+	}
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
@@ -0,0 +1,93 @@
+package qrcode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_ReedSolomonRemainder(t *testing.T) {
+
+	// "HELLO WORLD" encoded at 1-M, from the worked example in the specification.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	result := reedSolomonRemainder(data, reedSolomonDivisor(len(expected)))
+	if !bytes.Equal(result, expected) {
+		t.Errorf("Did not get the expected result. Expected ['%v'] Result ['%v']", expected, result)
+	}
+}
+
+func Test_FormatBits(t *testing.T) {
+
+	// Format information for level L from the specification, indexed by mask.
+	expected := []string{
+		"111011111000100", "111001011110011", "111110110101010", "111100010011101",
+		"110011000101111", "110001100011000", "110110001000001", "110100101110110",
+	}
+
+	for mask, bits := range expected {
+		code := newCode(1)
+		code.drawFormatBits(mask)
+
+		// Read back the copy below the top right finder pattern, most significant bit last.
+		var result strings.Builder
+		for i := 14; i >= 8; i-- {
+			result.WriteString(module(code, 8, code.Size-15+i))
+		}
+		for i := 7; i >= 0; i-- {
+			result.WriteString(module(code, code.Size-1-i, 8))
+		}
+		if result.String() != bits {
+			t.Errorf("Did not get the expected format bits for mask [%v]. Expected ['%v'] Result ['%v']", mask, bits, result.String())
+		}
+	}
+}
+
+func Test_Encode(t *testing.T) {
+	scenarios := []struct {
+		text         string
+		expectedSize int
+		expectError  bool
+	}{
+		{text: "https://okta.com/activate", expectedSize: 25},
+		{text: "https://okta-domain.com/activate?user_code=ABCD1234", expectedSize: 29},
+		{text: strings.Repeat("a", 271), expectedSize: 57},
+		{text: strings.Repeat("a", 272), expectError: true},
+	}
+
+	for _, test := range scenarios {
+		code, err := Encode(test.text)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Failed to reject text that is too long")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error [%v]", err)
+		}
+		if code.Size != test.expectedSize {
+			t.Errorf("Did not get the expected size. Expected ['%v'] Result ['%v']", test.expectedSize, code.Size)
+		}
+
+		// Each corner but the bottom right holds a finder pattern.
+		for _, corner := range [][2]int{{0, 0}, {code.Size - 7, 0}, {0, code.Size - 7}} {
+			if !code.Dark(corner[0], corner[1]) || code.Dark(corner[0]+1, corner[1]+1) || !code.Dark(corner[0]+3, corner[1]+3) {
+				t.Errorf("Missing finder pattern at [%v]", corner)
+			}
+		}
+
+		rendered := code.Terminal()
+		if lines := strings.Count(rendered, "\n"); lines != (code.Size+5)/2 {
+			t.Errorf("Did not render the expected number of lines. Expected ['%v'] Result ['%v']", (code.Size+5)/2, lines)
+		}
+	}
+}
+
+func module(code *Code, x int, y int) string {
+	if code.Dark(x, y) {
+		return "1"
+	}
+	return "0"
+}
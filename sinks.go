@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/js10x/okta-token-vendor/vendor"
+)
+
+// Token handed to the output sinks, and to the -template.
+type sinkToken struct {
+	AccessToken string
+	IDToken     string
+	TokenType   string
+	Scope       string
+	// When the access token expires, zero when unknown.
+	ExpiresAt time.Time
+	Username  string
+	Issuer    string
+}
+
+// Exit code when the token was vended, but could not be written everywhere it was asked for.
+const exitOutputFailed = 1
+
+// Somewhere the access token is written to each time one is received.
+type tokenSink struct {
+	name string
+	// File written by the sink, locked while writing it with -lock. Empty for the others.
+	path  string
+	write func(token sinkToken) error
+}
+
+// Flags choosing where the access token is written, on top of -o.
+type sinkFlags struct {
+	envFile, envKey, kubeSecret, kubeName, kubeNamespace, postmanEnv, postmanVar, httpieSession, netrc, netrcMachine, netrcLogin, template string
+	clipboard, lock                                                                                                                        bool
+	lockTimeout                                                                                                                            time.Duration
+}
+
+func (s *sinkFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&s.envFile, "env-file", "", "Write the access token to the -env-key of the provided .env file, keeping the other keys.")
+	fs.StringVar(&s.envKey, "env-key", "ACCESS_TOKEN", "The key of the -env-file holding the access token.")
+	fs.StringVar(&s.kubeSecret, "kube-secret", "", "Write the access token as a Kubernetes Secret manifest to the provided YAML file.")
+	fs.StringVar(&s.kubeName, "kube-name", "okta-token", "The name of the -kube-secret.")
+	fs.StringVar(&s.kubeNamespace, "kube-namespace", "", "The namespace of the -kube-secret.")
+	fs.StringVar(&s.postmanEnv, "postman-env", "", "Write the access token to the -postman-var of the provided Postman environment file.")
+	fs.StringVar(&s.postmanVar, "postman-var", "access_token", "The variable of the -postman-env holding the access token.")
+	fs.StringVar(&s.httpieSession, "httpie-session", "", "Write the access token to the Authorization header of the provided HTTPie session file.")
+	fs.StringVar(&s.netrc, "netrc", "", "Write the access token as the password of the -netrc-machine entry of the provided .netrc file.")
+	fs.StringVar(&s.netrcMachine, "netrc-machine", "", "The host of the API the -netrc entry is for.")
+	fs.StringVar(&s.netrcLogin, "netrc-login", "oauth2", "The login of the -netrc entry.")
+	fs.BoolVar(&s.lock, "lock", false, "Lock the files written while writing them, so that concurrent runs, e.g. parallel CI jobs, take turns.")
+	fs.DurationVar(&s.lockTimeout, "lock-timeout", 30*time.Second, "How long to wait for another run to release the -lock.")
+	fs.BoolVar(&s.clipboard, "clipboard", false, "Copy the access token to the clipboard.")
+	fs.StringVar(&s.template, "template", "", "Print the token to stdout with the provided Go template instead, e.g. '{{.AccessToken}}'. The fields are AccessToken, IDToken, TokenType, Scope, ExpiresAt, Username and Issuer.")
+}
+
+// Returns the sinks asked for with the flags.
+func (s *sinkFlags) sinks() ([]tokenSink, error) {
+
+	var sinks []tokenSink
+	if len(strings.TrimSpace(s.envFile)) > 0 {
+		sinks = append(sinks, tokenSink{name: "env file", path: s.envFile, write: func(token sinkToken) error {
+			return writeEnvFile(s.envFile, s.envKey, token.AccessToken)
+		}})
+	}
+	if len(strings.TrimSpace(s.kubeSecret)) > 0 {
+		sinks = append(sinks, tokenSink{name: "Kubernetes Secret", path: s.kubeSecret, write: func(token sinkToken) error {
+			return vendor.WriteFileAtomic(s.kubeSecret, kubeSecretManifest(s.kubeName, s.kubeNamespace, token.AccessToken))
+		}})
+	}
+	if len(strings.TrimSpace(s.postmanEnv)) > 0 {
+		sinks = append(sinks, tokenSink{name: "Postman environment", path: s.postmanEnv, write: func(token sinkToken) error {
+			return writePostmanEnvironment(s.postmanEnv, s.postmanVar, token.AccessToken)
+		}})
+	}
+	if len(strings.TrimSpace(s.httpieSession)) > 0 {
+		sinks = append(sinks, tokenSink{name: "HTTPie session", path: s.httpieSession, write: func(token sinkToken) error {
+			return writeHTTPieSession(s.httpieSession, token.AccessToken)
+		}})
+	}
+	if len(strings.TrimSpace(s.netrc)) > 0 {
+		if len(strings.TrimSpace(s.netrcMachine)) == 0 {
+			return nil, fmt.Errorf("You must specify the host of the -netrc entry with -netrc-machine")
+		}
+		sinks = append(sinks, tokenSink{name: "netrc file", path: s.netrc, write: func(token sinkToken) error {
+			return writeNetrc(s.netrc, s.netrcMachine, s.netrcLogin, token.AccessToken)
+		}})
+	}
+	if s.clipboard {
+		sinks = append(sinks, tokenSink{name: "clipboard", write: func(token sinkToken) error {
+			return copyToClipboard(token.AccessToken)
+		}})
+	}
+	if len(s.template) > 0 {
+		tmpl, err := template.New("token").Parse(s.template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -template: %v", err)
+		}
+		sinks = append(sinks, tokenSink{name: "template", write: func(token sinkToken) error {
+			var out bytes.Buffer
+			if err := tmpl.Execute(&out, token); err != nil {
+				return err
+			}
+			fmt.Println(strings.TrimRight(out.String(), "\n"))
+			return nil
+		}})
+	}
+	return sinks, nil
+}
+
+// Sets the key of the .env file to the value, keeping the other lines as they are, and the
+// indent, export prefix and line ending of the key's line.
+func writeEnvFile(path string, key string, value string) error {
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Files with CRLF line endings keep them for the lines added too.
+	eol := ""
+	if strings.Contains(string(content), "\r\n") {
+		eol = "\r"
+	}
+
+	var lines []string
+	found := false
+	for _, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+		rest := strings.TrimLeft(line, " \t")
+		if strings.HasPrefix(rest, "export ") {
+			rest = strings.TrimLeft(strings.TrimPrefix(rest, "export "), " \t")
+		}
+		if strings.HasPrefix(rest, key+"=") {
+			if found {
+				continue
+			}
+			prefix := line[:len(line)-len(rest)]
+			lineEnd := ""
+			if strings.HasSuffix(line, "\r") {
+				lineEnd = "\r"
+			}
+			line = prefix + key + "=" + value + lineEnd
+			found = true
+		}
+		if len(lines) > 0 || len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	if !found {
+		lines = append(lines, key+"="+value+eol)
+	}
+	return vendor.WriteFileAtomic(path, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// Returns a Kubernetes Secret manifest holding the access token.
+func kubeSecretManifest(name string, namespace string, accessToken string) []byte {
+	var manifest strings.Builder
+	manifest.WriteString("apiVersion: v1\nkind: Secret\nmetadata:\n")
+	fmt.Fprintf(&manifest, "  name: %v\n", name)
+	if len(strings.TrimSpace(namespace)) > 0 {
+		fmt.Fprintf(&manifest, "  namespace: %v\n", namespace)
+	}
+	manifest.WriteString("type: Opaque\ndata:\n")
+	fmt.Fprintf(&manifest, "  access_token: %v\n", base64.StdEncoding.EncodeToString([]byte(accessToken)))
+	return []byte(manifest.String())
+}
+
+// Sets the variable of the Postman environment file to the token, creating the environment
+// when the file does not exist yet.
+func writePostmanEnvironment(path string, variable string, accessToken string) error {
+
+	environment := map[string]interface{}{
+		"name":                    "oktv",
+		"values":                  []interface{}{},
+		"_postman_variable_scope": "environment",
+	}
+	if err := readJSONFile(path, &environment); err != nil {
+		return err
+	}
+
+	values, _ := environment["values"].([]interface{})
+	found := false
+	for _, value := range values {
+		if entry, ok := value.(map[string]interface{}); ok && entry["key"] == variable {
+			entry["value"] = accessToken
+			found = true
+		}
+	}
+	if !found {
+		values = append(values, map[string]interface{}{"key": variable, "value": accessToken, "type": "secret", "enabled": true})
+	}
+	environment["values"] = values
+	return writeJSONFile(path, environment)
+}
+
+// Sets the Authorization header of the HTTPie session file to the token. Both the list of
+// headers of HTTPie 3 and the map of older versions are understood.
+func writeHTTPieSession(path string, accessToken string) error {
+
+	session := map[string]interface{}{}
+	if err := readJSONFile(path, &session); err != nil {
+		return err
+	}
+
+	authorization := "Bearer " + accessToken
+	switch headers := session["headers"].(type) {
+	case map[string]interface{}:
+		headers["Authorization"] = authorization
+	case []interface{}:
+		found := false
+		for _, header := range headers {
+			if entry, ok := header.(map[string]interface{}); ok && strings.EqualFold(fmt.Sprint(entry["name"]), "Authorization") {
+				entry["value"] = authorization
+				found = true
+			}
+		}
+		if !found {
+			session["headers"] = append(headers, map[string]interface{}{"name": "Authorization", "value": authorization})
+		}
+	default:
+		session["headers"] = []interface{}{map[string]interface{}{"name": "Authorization", "value": authorization}}
+	}
+	return writeJSONFile(path, session)
+}
+
+// Sets the password of the machine's entry of the .netrc file to the token, keeping the other
+// entries and the comments as they are. A new entry goes before the default entry, which must
+// be the last one. Files with macro definitions are refused, since their bodies can't be rewritten.
+func writeNetrc(path string, machine string, login string, accessToken string) error {
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var lines []string
+	if len(strings.TrimSpace(string(content))) > 0 {
+		lines = strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	}
+
+	// Each token of the file, on the line it was found on.
+	type netrcToken struct {
+		line int
+		text string
+	}
+	var tokens []netrcToken
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, field := range strings.Fields(line) {
+			if field == "macdef" {
+				return fmt.Errorf("the netrc file [%v] has macro definitions, which are not supported", path)
+			}
+			tokens = append(tokens, netrcToken{line: i, text: field})
+		}
+	}
+	isKeyword := func(i int) bool {
+		if i > 0 {
+			switch tokens[i-1].text {
+			case "machine", "login", "password", "account":
+				return false
+			}
+		}
+		return true
+	}
+	isMachine := func(i int) bool {
+		return tokens[i].text == "machine" && isKeyword(i) && i+1 < len(tokens) && tokens[i+1].text == machine
+	}
+	exists := false
+	for i := range tokens {
+		exists = exists || isMachine(i)
+	}
+
+	// Drops the tokens of the machine's entries, and finds the token the new entry goes before.
+	dropped := make([]bool, len(tokens))
+	insertAt, inEntry := -1, false
+	for i, token := range tokens {
+		switch {
+		case isMachine(i):
+			inEntry = true
+			if insertAt < 0 {
+				insertAt = i
+			}
+		case (token.text == "machine" || token.text == "default") && isKeyword(i):
+			inEntry = false
+			if token.text == "default" && !exists && insertAt < 0 {
+				insertAt = i
+			}
+		}
+		dropped[i] = inEntry
+	}
+
+	entry := fmt.Sprintf("machine %v login %v password %v", machine, login, accessToken)
+	var netrc []string
+	next := 0
+	for i, line := range lines {
+		first := next
+		for next < len(tokens) && tokens[next].line == i {
+			next++
+		}
+		changed := false
+		for j := first; j < next; j++ {
+			changed = changed || dropped[j] || j == insertAt
+		}
+		if !changed {
+			netrc = append(netrc, line)
+			continue
+		}
+
+		// Rewrites the line with the tokens kept, around the new entry.
+		var kept []string
+		for j := first; j < next; j++ {
+			if j == insertAt {
+				if len(kept) > 0 {
+					netrc = append(netrc, strings.Join(kept, " "))
+				}
+				netrc, kept = append(netrc, entry), nil
+			}
+			if !dropped[j] {
+				kept = append(kept, tokens[j].text)
+			}
+		}
+		if len(kept) > 0 {
+			netrc = append(netrc, strings.Join(kept, " "))
+		}
+	}
+	if insertAt < 0 {
+		netrc = append(netrc, entry)
+	}
+	return vendor.WriteFileAtomic(path, []byte(strings.Join(netrc, "\n")+"\n"))
+}
+
+// Copies the text to the clipboard with the tool of the platform.
+func copyToClipboard(text string) error {
+
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "windows":
+		candidates = [][]string{{"clip"}}
+	case "darwin":
+		candidates = [][]string{{"pbcopy"}}
+	default:
+		candidates = [][]string{{"wl-copy"}, {"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}}
+	}
+	for _, candidate := range candidates {
+		if _, err := exec.LookPath(candidate[0]); err != nil {
+			continue
+		}
+		command := exec.Command(candidate[0], candidate[1:]...)
+		command.Stdin = strings.NewReader(text)
+		return command.Run()
+	}
+	return fmt.Errorf("no clipboard tool found, install wl-copy, xclip or xsel")
+}
+
+// Reads the JSON file into the value, leaving it as it is when the file does not exist yet.
+func readJSONFile(path string, value interface{}) error {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, value); err != nil {
+		return fmt.Errorf("failed to parse [%v]: %v", path, err)
+	}
+	return nil
+}
+
+func writeJSONFile(path string, value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return vendor.WriteFileAtomic(path, append(content, '\n'))
+}
+
+// Writes the tokens received to the sinks, reporting the ones that failed. Returns whether
+// they all succeeded.
+func (s *sinkFlags) write(sinks []tokenSink, event vendor.TokenEvent) bool {
+	token := sinkToken{
+		AccessToken: event.Token.AccessToken,
+		IDToken:     event.Token.IDToken,
+		TokenType:   event.Token.TokenType,
+		Scope:       event.Token.Scope,
+		ExpiresAt:   event.ExpiresAt,
+		Username:    event.Username,
+		Issuer:      event.Issuer,
+	}
+	succeeded := true
+	for _, sink := range sinks {
+		if err := s.writeSink(sink, token); err != nil {
+			fmt.Fprintf(os.Stderr, "Error occurred when writing the token to the %v: %v\n", sink.name, err)
+			succeeded = false
+		}
+	}
+	return succeeded
+}
+
+func (s *sinkFlags) writeSink(sink tokenSink, token sinkToken) error {
+	if s.lock && len(sink.path) > 0 {
+		unlock, err := vendor.LockFile(sink.path, s.lockTimeout)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	return sink.write(token)
+}
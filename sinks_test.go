@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_WriteEnvFile(t *testing.T) {
+
+	scenarios := []struct {
+		name     string
+		content  *string
+		expected string
+	}{
+		{name: "missing file", expected: "ACCESS_TOKEN=TOKEN\n"},
+		{name: "new key", content: str("API_URL=https://api.example.com\n"), expected: "API_URL=https://api.example.com\nACCESS_TOKEN=TOKEN\n"},
+		{name: "existing key", content: str("# tokens\nACCESS_TOKEN=old\nAPI_URL=https://api.example.com\n"), expected: "# tokens\nACCESS_TOKEN=TOKEN\nAPI_URL=https://api.example.com\n"},
+		{name: "exported key", content: str("export ACCESS_TOKEN=old\n"), expected: "export ACCESS_TOKEN=TOKEN\n"},
+		{name: "indented key", content: str("  ACCESS_TOKEN=old\n"), expected: "  ACCESS_TOKEN=TOKEN\n"},
+		{name: "duplicate keys", content: str("ACCESS_TOKEN=old\nAPI_URL=https://api.example.com\nACCESS_TOKEN=older\n"), expected: "ACCESS_TOKEN=TOKEN\nAPI_URL=https://api.example.com\n"},
+		{name: "crlf", content: str("API_URL=https://api.example.com\r\nACCESS_TOKEN=old\r\n"), expected: "API_URL=https://api.example.com\r\nACCESS_TOKEN=TOKEN\r\n"},
+		{name: "crlf new key", content: str("API_URL=https://api.example.com\r\n"), expected: "API_URL=https://api.example.com\r\nACCESS_TOKEN=TOKEN\r\n"},
+		{name: "trailing spaces", content: str("ACCESS_TOKEN=old  \nAPI_URL=https://api.example.com\n"), expected: "ACCESS_TOKEN=TOKEN\nAPI_URL=https://api.example.com\n"},
+		{name: "exported crlf", content: str("  export  ACCESS_TOKEN=old \r\n"), expected: "  export  ACCESS_TOKEN=TOKEN\r\n"},
+		{name: "key prefix", content: str("ACCESS_TOKEN_URL=https://okta.example.com\n"), expected: "ACCESS_TOKEN_URL=https://okta.example.com\nACCESS_TOKEN=TOKEN\n"},
+	}
+
+	for _, test := range scenarios {
+		path := sinkFile(t, ".env", test.content)
+		if err := writeEnvFile(path, "ACCESS_TOKEN", "TOKEN"); err != nil {
+			t.Fatalf("[%v] Unexpected error [%v]", test.name, err)
+		}
+		if result := readSinkFile(t, path); result != test.expected {
+			t.Errorf("[%v] Did not get the expected result. Expected ['%v'] Result ['%v']", test.name, test.expected, result)
+		}
+	}
+}
+
+func Test_WriteNetrc(t *testing.T) {
+
+	scenarios := []struct {
+		name          string
+		content       *string
+		expected      string
+		expectedError bool
+	}{
+		{name: "missing file", expected: "machine api.example.com login oauth2 password TOKEN\n"},
+		{name: "new entry", content: str("machine other.example.com login user password pw\n"), expected: "machine other.example.com login user password pw\nmachine api.example.com login oauth2 password TOKEN\n"},
+		{name: "existing entry", content: str("machine api.example.com login oauth2 password old\nmachine other.example.com login user password pw\n"), expected: "machine api.example.com login oauth2 password TOKEN\nmachine other.example.com login user password pw\n"},
+		{name: "multiline entry", content: str("machine api.example.com\n  login oauth2\n  password old\nmachine other.example.com\n  login user\n  password pw\n"), expected: "machine api.example.com login oauth2 password TOKEN\nmachine other.example.com\n  login user\n  password pw\n"},
+		{name: "entries on one line", content: str("machine a login x password y machine api.example.com login oauth2 password old machine b login q password r\n"), expected: "machine a login x password y\nmachine api.example.com login oauth2 password TOKEN\nmachine b login q password r\n"},
+		{name: "duplicate entries", content: str("machine api.example.com login oauth2 password old\nmachine other.example.com login user password pw\nmachine api.example.com login oauth2 password older\n"), expected: "machine api.example.com login oauth2 password TOKEN\nmachine other.example.com login user password pw\n"},
+		{name: "comments", content: str("# work\nmachine api.example.com login oauth2 password old\n\n# home\nmachine other.example.com login user password pw\n"), expected: "# work\nmachine api.example.com login oauth2 password TOKEN\n\n# home\nmachine other.example.com login user password pw\n"},
+		{name: "default entry", content: str("machine other.example.com login user password pw\ndefault login anonymous password guest\n"), expected: "machine other.example.com login user password pw\nmachine api.example.com login oauth2 password TOKEN\ndefault login anonymous password guest\n"},
+		{name: "default login", content: str("machine other.example.com login default password pw\n"), expected: "machine other.example.com login default password pw\nmachine api.example.com login oauth2 password TOKEN\n"},
+		{name: "macro definitions", content: str("machine other.example.com login user password pw\nmacdef init\ncd /pub\n\n"), expectedError: true},
+	}
+
+	for _, test := range scenarios {
+		path := sinkFile(t, ".netrc", test.content)
+		err := writeNetrc(path, "api.example.com", "oauth2", "TOKEN")
+		if test.expectedError {
+			if err == nil {
+				t.Errorf("[%v] Expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%v] Unexpected error [%v]", test.name, err)
+		}
+		if result := readSinkFile(t, path); result != test.expected {
+			t.Errorf("[%v] Did not get the expected result. Expected ['%v'] Result ['%v']", test.name, test.expected, result)
+		}
+	}
+}
+
+func Test_WritePostmanEnvironment(t *testing.T) {
+
+	scenarios := []struct {
+		name          string
+		content       *string
+		expected      string
+		expectedError bool
+	}{
+		{name: "missing file", expected: `{"_postman_variable_scope":"environment","name":"oktv","values":[{"enabled":true,"key":"access_token","type":"secret","value":"TOKEN"}]}`},
+		{name: "new variable", content: str(`{"name":"dev","values":[{"key":"base_url","value":"https://api.example.com"}]}`), expected: `{"_postman_variable_scope":"environment","name":"dev","values":[{"key":"base_url","value":"https://api.example.com"},{"enabled":true,"key":"access_token","type":"secret","value":"TOKEN"}]}`},
+		{name: "existing variable", content: str(`{"name":"dev","values":[{"enabled":false,"key":"access_token","value":"old"}]}`), expected: `{"_postman_variable_scope":"environment","name":"dev","values":[{"enabled":false,"key":"access_token","value":"TOKEN"}]}`},
+		{name: "duplicate variables", content: str(`{"name":"dev","values":[{"key":"access_token","value":"old"},{"key":"access_token","value":"older"}]}`), expected: `{"_postman_variable_scope":"environment","name":"dev","values":[{"key":"access_token","value":"TOKEN"},{"key":"access_token","value":"TOKEN"}]}`},
+		{name: "invalid file", content: str(`not json`), expectedError: true},
+	}
+
+	for _, test := range scenarios {
+		path := sinkFile(t, "dev.postman_environment.json", test.content)
+		err := writePostmanEnvironment(path, "access_token", "TOKEN")
+		if test.expectedError {
+			if err == nil {
+				t.Errorf("[%v] Expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%v] Unexpected error [%v]", test.name, err)
+		}
+		if result := readSinkJSON(t, path); result != test.expected {
+			t.Errorf("[%v] Did not get the expected result. Expected ['%v'] Result ['%v']", test.name, test.expected, result)
+		}
+	}
+}
+
+func Test_WriteHTTPieSession(t *testing.T) {
+
+	scenarios := []struct {
+		name     string
+		content  *string
+		expected string
+	}{
+		{name: "missing file", expected: `{"headers":[{"name":"Authorization","value":"Bearer TOKEN"}]}`},
+		{name: "httpie 2 headers", content: str(`{"auth":{"type":null},"headers":{"Accept":"application/json","Authorization":"Bearer old"}}`), expected: `{"auth":{"type":null},"headers":{"Accept":"application/json","Authorization":"Bearer TOKEN"}}`},
+		{name: "httpie 2 new header", content: str(`{"headers":{"Accept":"application/json"}}`), expected: `{"headers":{"Accept":"application/json","Authorization":"Bearer TOKEN"}}`},
+		{name: "httpie 3 headers", content: str(`{"headers":[{"name":"Accept","value":"application/json"},{"name":"authorization","value":"Bearer old"}]}`), expected: `{"headers":[{"name":"Accept","value":"application/json"},{"name":"authorization","value":"Bearer TOKEN"}]}`},
+		{name: "httpie 3 new header", content: str(`{"headers":[{"name":"Accept","value":"application/json"}]}`), expected: `{"headers":[{"name":"Accept","value":"application/json"},{"name":"Authorization","value":"Bearer TOKEN"}]}`},
+		{name: "httpie 3 duplicate headers", content: str(`{"headers":[{"name":"Authorization","value":"Bearer old"},{"name":"Authorization","value":"Bearer older"}]}`), expected: `{"headers":[{"name":"Authorization","value":"Bearer TOKEN"},{"name":"Authorization","value":"Bearer TOKEN"}]}`},
+	}
+
+	for _, test := range scenarios {
+		path := sinkFile(t, "session.json", test.content)
+		if err := writeHTTPieSession(path, "TOKEN"); err != nil {
+			t.Fatalf("[%v] Unexpected error [%v]", test.name, err)
+		}
+		if result := readSinkJSON(t, path); result != test.expected {
+			t.Errorf("[%v] Did not get the expected result. Expected ['%v'] Result ['%v']", test.name, test.expected, result)
+		}
+	}
+}
+
+func Test_KubeSecretManifest(t *testing.T) {
+
+	scenarios := []struct {
+		name      string
+		namespace string
+		expected  string
+	}{
+		{name: "default namespace", expected: "apiVersion: v1\nkind: Secret\nmetadata:\n  name: okta-token\ntype: Opaque\ndata:\n  access_token: VE9LRU4=\n"},
+		{name: "namespace", namespace: "apps", expected: "apiVersion: v1\nkind: Secret\nmetadata:\n  name: okta-token\n  namespace: apps\ntype: Opaque\ndata:\n  access_token: VE9LRU4=\n"},
+	}
+
+	for _, test := range scenarios {
+		if result := string(kubeSecretManifest("okta-token", test.namespace, "TOKEN")); result != test.expected {
+			t.Errorf("[%v] Did not get the expected result. Expected ['%v'] Result ['%v']", test.name, test.expected, result)
+		}
+	}
+}
+
+func Test_Template(t *testing.T) {
+
+	scenarios := []struct {
+		name          string
+		template      string
+		expected      string
+		expectedError bool
+	}{
+		{name: "access token", template: "{{.AccessToken}}", expected: "TOKEN\n"},
+		{name: "fields", template: "{{.TokenType}} {{.Scope}} {{.Username}}\n", expected: "Bearer openid user\n"},
+		{name: "invalid template", template: "{{.AccessToken", expectedError: true},
+		{name: "unknown field", template: "{{.RefreshToken}}", expectedError: true},
+	}
+
+	for _, test := range scenarios {
+		flags := &sinkFlags{template: test.template}
+		sinks, err := flags.sinks()
+		printed := ""
+		if err == nil {
+			printed, err = captureStdout(t, func() error {
+				return sinks[0].write(sinkToken{AccessToken: "TOKEN", TokenType: "Bearer", Scope: "openid", Username: "user"})
+			})
+		}
+		if test.expectedError {
+			if err == nil {
+				t.Errorf("[%v] Expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%v] Unexpected error [%v]", test.name, err)
+		}
+		if printed != test.expected {
+			t.Errorf("[%v] Did not get the expected result. Expected ['%v'] Result ['%v']", test.name, test.expected, printed)
+		}
+	}
+}
+
+func str(s string) *string {
+	return &s
+}
+
+// Returns the path of the file in a temporary directory, holding the content unless nil.
+func sinkFile(t *testing.T, name string, content *string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if content != nil {
+		if err := ioutil.WriteFile(path, []byte(*content), 0600); err != nil {
+			t.Fatalf("Unexpected error [%v]", err)
+		}
+	}
+	return path
+}
+
+func readSinkFile(t *testing.T, path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	return string(content)
+}
+
+// Returns the JSON file compacted, with the keys of its objects sorted.
+func readSinkJSON(t *testing.T, path string) string {
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, []byte(readSinkFile(t, path))); err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	return compacted.String()
+}
+
+// Runs the function, returning what it printed to stdout.
+func captureStdout(t *testing.T, f func() error) (string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Unexpected error [%v]", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = f()
+	os.Stdout = stdout
+	w.Close()
+	printed, _ := ioutil.ReadAll(r)
+	return string(printed), err
+}
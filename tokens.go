@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/js10x/okta-token-vendor/vendor"
+)
+
+// Gets a new access token with a refresh token, the one cached by exec and call by default.
+func setupRefresh(fs *flag.FlagSet) func(args []string) {
+
+	var f vendFlags
+	var refreshToken string
+	f.register(fs)
+	fs.StringVar(&refreshToken, "refresh-token", "", "The refresh token to use. Defaults to the cached one, vended with the offline_access scope.")
+	return func(args []string) {
+		if err := f.parse(fs, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		cachePath := f.cachePath()
+		if len(strings.TrimSpace(refreshToken)) == 0 {
+			if cached := loadCachedToken(cachePath); cached != nil {
+				refreshToken = cached.RefreshToken
+			}
+		}
+		oktv := vendor.NewTokenVendor(f.options())
+		switch {
+		case len(strings.TrimSpace(refreshToken)) == 0:
+			fmt.Fprintf(os.Stderr, "You must specify the refresh token with -refresh-token, none is cached\n")
+			os.Exit(1)
+		case len(strings.TrimSpace(oktv.Ops.ClientID)) == 0:
+			fmt.Fprintf(os.Stderr, "You must specify a CLIENT ID\n")
+			os.Exit(1)
+		case len(strings.TrimSpace(oktv.Ops.Issuer)) == 0:
+			fmt.Fprintf(os.Stderr, "You must specify an ISSUER\n")
+			os.Exit(1)
+		}
+
+		accessToken, err := oktv.RefreshAccessToken(refreshToken)
+		f.saveHAR()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		if len(accessToken.RefreshToken) == 0 {
+			accessToken.RefreshToken = refreshToken
+		}
+		if err := writeCachedToken(cachePath, &cachedToken{AccessTokenResponse: *accessToken, ExpiresAt: accessToken.Expiry(time.Now())}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error occurred when caching the token: %v\n", err)
+		}
+		f.printToken(accessToken)
+	}
+}
+
+// Prints the header and claims of a JWT, without verifying it.
+func setupDecode(fs *flag.FlagSet) func(args []string) {
+	return func(args []string) {
+		fs.Parse(args)
+		decoded, err := vendor.DecodeToken(readTokenArg(fs))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		printDecodedToken(decoded)
+	}
+}
+
+// Verifies a JWT against the keys of the ISSUER, and prints it when it is valid.
+func setupVerify(fs *flag.FlagSet) func(args []string) {
+
+	var f vendFlags
+	f.register(fs)
+	return func(args []string) {
+		if err := f.parse(fs, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		oktv := vendor.NewTokenVendor(f.options())
+		if len(strings.TrimSpace(oktv.Ops.Issuer)) == 0 {
+			fmt.Fprintf(os.Stderr, "You must specify an ISSUER\n")
+			os.Exit(1)
+		}
+
+		decoded, err := oktv.VerifyToken(readTokenArg(fs))
+		f.saveHAR()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "The token is INVALID: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "The token is valid.\n")
+		printDecodedToken(decoded)
+	}
+}
+
+// Revokes a token, or the cached tokens when none is given.
+func setupRevoke(fs *flag.FlagSet) func(args []string) {
+
+	var f vendFlags
+	var hint string
+	f.register(fs)
+	fs.StringVar(&hint, "hint", "", "The type of the token: access_token or refresh_token.")
+	return func(args []string) {
+		if err := f.parse(fs, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		oktv := vendor.NewTokenVendor(f.options())
+		switch {
+		case len(strings.TrimSpace(oktv.Ops.ClientID)) == 0:
+			fmt.Fprintf(os.Stderr, "You must specify a CLIENT ID\n")
+			os.Exit(1)
+		case len(strings.TrimSpace(oktv.Ops.Issuer)) == 0:
+			fmt.Fprintf(os.Stderr, "You must specify an ISSUER\n")
+			os.Exit(1)
+		}
+		defer f.saveHAR()
+
+		if fs.NArg() > 0 {
+			if err := oktv.RevokeToken(fs.Arg(0), hint); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Token revoked.\n")
+			return
+		}
+
+		// Revoke the cached tokens, the refresh token first since it can mint new ones.
+		cachePath := f.cachePath()
+		cached := loadCachedToken(cachePath)
+		if cached == nil {
+			fmt.Fprintf(os.Stderr, "You must specify the token to revoke, none is cached\n")
+			os.Exit(1)
+		}
+		if len(cached.RefreshToken) > 0 {
+			if err := oktv.RevokeToken(cached.RefreshToken, "refresh_token"); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := oktv.RevokeToken(cached.AccessToken, "access_token"); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		os.Remove(cachePath)
+		fmt.Fprintf(os.Stderr, "Cached tokens revoked.\n")
+	}
+}
+
+// Returns the token given as the first argument, or read from stdin when there is none or it
+// is "-", e.g. oktv decode < token.txt.
+func readTokenArg(fs *flag.FlagSet) string {
+	if fs.NArg() > 0 && fs.Arg(0) != "-" {
+		return strings.TrimSpace(fs.Arg(0))
+	}
+	content, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error occurred when reading the token from stdin: %v\n", err)
+		os.Exit(1)
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// Prints the header and claims as JSON, and the times of the date claims to stderr.
+func printDecodedToken(decoded *vendor.DecodedToken) {
+
+	content, _ := json.MarshalIndent(map[string]interface{}{"header": decoded.Header, "claims": decoded.Claims}, "", "  ")
+	fmt.Println(string(content))
+
+	var claims []string
+	for _, claim := range []string{"iat", "nbf", "auth_time", "exp"} {
+		if at := decoded.Time(claim); !at.IsZero() {
+			claims = append(claims, fmt.Sprintf("%v: %v", claim, at.Local().Format(time.RFC1123)))
+		}
+	}
+	if exp := decoded.Time("exp"); !exp.IsZero() {
+		if remaining := time.Until(exp); remaining > 0 {
+			claims = append(claims, fmt.Sprintf("expires in %v", remaining.Round(time.Second)))
+		} else {
+			claims = append(claims, fmt.Sprintf("EXPIRED %v ago", (-remaining).Round(time.Second)))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%v\n", strings.Join(claims, "\n"))
+}